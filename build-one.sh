@@ -4,7 +4,7 @@ set -e
 
 PROJECT='gophor'
 OUTDIR='build'
-VERSION="$(cat 'constants.go' | grep -E '^\s*GophorVersion' | sed -e 's|\s*GophorVersion = \"||' -e 's|\"\s*$||')"
+VERSION="$(cat 'gopher/constants.go' | grep -E '^\s*GophorVersion' | sed -e 's|\s*GophorVersion = \"||' -e 's|\"\s*$||')"
 GOVERSION="$(go version | sed -e 's|^go version go||' -e 's|\s.*$||')"
 LOGFILE='build.log'
 
@@ -0,0 +1,157 @@
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "strings"
+    "encoding/json"
+    "github.com/EaterLabs/gophor/gopher"
+    "github.com/EaterLabs/gophor/gopher/client"
+)
+
+/* gophor check [flags] [server flags]
+ * Check gophermaps under the root, printing problems found, or with
+ * -links check their external links, reporting those found dead.
+ * Exits non-zero if there were any, for use in pre-publish hooks.
+ */
+func runCheck(args []string) int {
+    flags := flag.NewFlagSet("check", flag.ExitOnError)
+    flags.Usage = func() {
+        fmt.Fprintf(flags.Output(), "Usage: gophor check [-links [-format gophermap|json]] [server flags]\n")
+        flags.PrintDefaults()
+    }
+    links       := flags.Bool("links", false, "Check external gopher and 'URL:' links instead, reporting those found dead.")
+    format      := flags.String("format", "gophermap", "Change dead link report format -- gophermap, json")
+    timeout     := flags.Duration("link-timeout", client.DefaultTimeout, "Change timeout for each external link.")
+    concurrency := flags.Int("link-concurrency", client.DefaultLinkConcurrency, "Change maximum number of external links checked at once.")
+
+    /* Same flags as the server so selectors are checked as they'd be served,
+     * logging disabled by default to keep output to the problems found
+     */
+    options := gopher.DefaultServerOptions()
+    options.LogType = 1
+    addServerFlags(flags, options)
+    flags.Parse(args)
+
+    if flags.NArg() != 0 || (*format != "gophermap" && *format != "json") {
+        flags.Usage()
+        return 2
+    }
+
+    server, gophorErr := gopher.NewServer(options)
+    if gophorErr != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up server: %s\n", gophorErr.Error())
+        return 2
+    }
+
+    if *links {
+        return runLinkCheck(server, client.NewLinkChecker(*timeout, *concurrency), *format)
+    }
+
+    problems := server.Check()
+    for _, problem := range problems {
+        fmt.Println(problem)
+    }
+
+    if len(problems) > 0 {
+        fmt.Fprintf(os.Stderr, "%d problem(s) found in %s\n", len(problems), options.RootDir)
+        return 1
+    }
+    return 0
+}
+
+/* Check server's external links, writing a report of dead links to stdout */
+func runLinkCheck(server *gopher.Server, checker *client.LinkChecker, format string) int {
+    results := checker.Check(server.ExternalLinks())
+
+    var err error
+    if format == "json" {
+        err = writeLinkReportJson(os.Stdout, results)
+    } else {
+        err = writeLinkReportGophermap(os.Stdout, results)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing report: %s\n", err.Error())
+        return 2
+    }
+
+    for _, result := range results {
+        if result.Err != nil {
+            return 1
+        }
+    }
+    return 0
+}
+
+/* Write dead links as a gophermap, each described by an info line
+ * followed by the dead item itself
+ */
+func writeLinkReportGophermap(w io.Writer, results []*client.LinkResult) error {
+    checked, dead := countLinks(results)
+    report := "!Dead links\n"
+    report += fmt.Sprintf("Checked %d link(s), %d dead\n", checked, dead)
+
+    for _, result := range results {
+        if result.Err == nil {
+            continue
+        }
+
+        /* Tabs would end the info line early */
+        reason := strings.Replace(result.Err.Error(), "\t", " ", -1)
+        report += "\n"
+        report += fmt.Sprintf("Dead link at %s:%d: %s\n", result.Path, result.Line, reason)
+        if result.ExternalURL() != "" {
+            report += string(result.Type)+result.URL+"\t"+result.Selector+"\n"
+        } else {
+            report += string(result.Type)+result.URL+"\t"+result.Selector+"\t"+result.Host+"\t"+result.Port+"\n"
+        }
+    }
+
+    _, err := io.WriteString(w, report+".\n")
+    return err
+}
+
+/* linkReport:
+ * JSON form of a dead link report.
+ */
+type linkReport struct {
+    Checked int              `json:"checked"`
+    Dead    []*deadLinkEntry `json:"dead"`
+}
+
+type deadLinkEntry struct {
+    Path  string `json:"path"`
+    Line  int    `json:"line"`
+    URL   string `json:"url"`
+    Error string `json:"error"`
+}
+
+func writeLinkReportJson(w io.Writer, results []*client.LinkResult) error {
+    checked, _ := countLinks(results)
+    report := &linkReport{ checked, make([]*deadLinkEntry, 0) }
+    for _, result := range results {
+        if result.Err != nil {
+            report.Dead = append(report.Dead, &deadLinkEntry{ result.Path, result.Line, result.URL, result.Err.Error() })
+        }
+    }
+
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(report)
+}
+
+/* Count links checked, and those found dead */
+func countLinks(results []*client.LinkResult) (int, int) {
+    checked, dead := 0, 0
+    for _, result := range results {
+        if result.Checked {
+            checked += 1
+        }
+        if result.Err != nil {
+            dead += 1
+        }
+    }
+    return checked, dead
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "html"
+    "path"
+    "strconv"
+    "net/url"
+    "path/filepath"
+    "github.com/EaterLabs/gophor/gopher"
+    "github.com/EaterLabs/gophor/gopher/client"
+)
+
+/* gophor export -o <dir> [-format html|gophermap] [server flags]
+ * Export every selector served from the root, plus generated ones
+ * linked to, rendered as served to the given hostname and port, as
+ * a static site.
+ */
+func runExport(args []string) int {
+    flags := flag.NewFlagSet("export", flag.ExitOnError)
+    flags.Usage = func() {
+        fmt.Fprintf(flags.Output(), "Usage: gophor export -o <dir> [-format html|gophermap] [server flags]\n")
+        flags.PrintDefaults()
+    }
+    outDir := flags.String("o", "", "Write exported site to this directory.")
+    format := flags.String("format", "html", "Change export format -- html (menus as linked pages), gophermap (menus as fully expanded gophermaps)")
+
+    /* Logging disabled by default to keep output to export errors */
+    options := gopher.DefaultServerOptions()
+    options.LogType = 1
+    addServerFlags(flags, options)
+    flags.Parse(args)
+
+    if flags.NArg() != 0 || *outDir == "" || (*format != "html" && *format != "gophermap") {
+        flags.Usage()
+        return 2
+    }
+
+    server, gophorErr := gopher.NewServer(options)
+    if gophorErr != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up server: %s\n", gophorErr.Error())
+        return 2
+    }
+
+    e := &exporter{
+        server,
+        &gopher.ConnHost{ Name: options.Hostname, Port: strconv.Itoa(options.Port) },
+        *outDir,
+        *format == "html",
+        make(map[string]bool),
+        make(map[string]string),
+        0,
+        0,
+    }
+    e.Export()
+
+    fmt.Fprintf(os.Stderr, "Exported %d selector(s) to %s, %d error(s)\n", e.exported, e.outDir, e.errors)
+    if e.errors > 0 {
+        return 1
+    }
+    return 0
+}
+
+/* exporter:
+ * Renders everything served from content under the root, then crawls
+ * menus' local links for generated selectors, writing each menu as an
+ * HTML page or gophermap and other items as-is.
+ */
+type exporter struct {
+    server   *gopher.Server
+    host     *gopher.ConnHost
+    outDir   string
+    html     bool
+    seen     map[string]bool
+    written  map[string]string
+    exported int
+    errors   int
+}
+
+func (e *exporter) Export() {
+    /* Start with all content under the root, linked to or not */
+    queue := make([]*client.Item, 0)
+    for _, content := range e.server.ContentItems() {
+        e.seen[content.Selector] = true
+        queue = append(queue, &client.Item{ Type: content.Type, Selector: content.Selector, Host: e.host.Name, Port: e.host.Port })
+    }
+
+    for len(queue) > 0 {
+        item := queue[0]
+        queue = queue[1:]
+
+        contents, gophorErr := e.server.Render(item.Selector, e.host)
+        if gophorErr != nil {
+            e.logError(item.Selector, gophorErr.Error())
+            continue
+        }
+
+        if item.Type != gopher.TypeDirectory {
+            /* Source gophermaps are replaced by the expanded menus */
+            if !e.html && path.Base(item.Selector) == gopher.GophermapFileStr {
+                continue
+            }
+            e.write(item.Selector, item.Selector, contents)
+            continue
+        }
+
+        /* Queue this menu's local items not seen yet, i.e. generated ones */
+        items := client.ParseMenu(contents)
+        for _, child := range items {
+            if e.isExported(child) && !e.seen[child.Selector] {
+                e.seen[child.Selector] = true
+                queue = append(queue, child)
+            }
+        }
+
+        if e.html {
+            e.write(item.Selector, path.Join(item.Selector, "index.html"), e.menuToHtml(item.Selector, items))
+        } else {
+            e.write(item.Selector, path.Join(item.Selector, gopher.GophermapFileStr), contents)
+        }
+    }
+}
+
+/* Check if item is on this server and can be exported: searches need a
+ * query, telnet items aren't files and 'URL:' links point elsewhere
+ */
+func (e *exporter) isExported(item *client.Item) bool {
+    switch item.Type {
+        case gopher.TypeSearch, gopher.TypeTelnet, gopher.TypeTn3270:
+            return false
+    }
+    return item.IsLink() && item.ExternalURL() == "" && item.Host == e.host.Name && item.Port == e.host.Port
+}
+
+/* Write exported selector to its output path (relative to the output
+ * directory), unless already written for another selector
+ */
+func (e *exporter) write(selector, outPath string, contents []byte) {
+    outPath = path.Clean("/"+outPath)
+    if other, ok := e.written[outPath]; ok {
+        e.logError(selector, outPath+" already exported for "+other)
+        return
+    }
+    e.written[outPath] = selector
+
+    filePath := filepath.Join(e.outDir, filepath.FromSlash(outPath))
+    err := os.MkdirAll(filepath.Dir(filePath), 0755)
+    if err == nil {
+        err = os.WriteFile(filePath, contents, 0644)
+    }
+    if err != nil {
+        e.logError(selector, err.Error())
+        return
+    }
+    e.exported += 1
+}
+
+func (e *exporter) logError(selector, message string) {
+    fmt.Fprintf(os.Stderr, "Error exporting %s: %s\n", selector, message)
+    e.errors += 1
+}
+
+/* Render menu at selector as an HTML page, in a preformatted block
+ * as menus are laid out for fixed width text
+ */
+func (e *exporter) menuToHtml(selector string, items []*client.Item) []byte {
+    /* Page title from the first title line, if any */
+    title, titled := selector, false
+    body := ""
+    for _, item := range items {
+        if item.Type == gopher.TypeInfo && item.Selector == "TITLE" && !titled {
+            title, titled = item.Display, true
+        }
+
+        href := e.itemHref(selector, item)
+        if href == "" {
+            body += html.EscapeString(item.Display)+"\n"
+        } else {
+            body += "<a href=\""+html.EscapeString(href)+"\">"+html.EscapeString(item.Display)+"</a>\n"
+        }
+    }
+
+    page := "<!DOCTYPE html>\n"+
+            "<html>\n"+
+            "<head>\n"+
+            "<meta charset=\"utf-8\">\n"+
+            "<title>"+html.EscapeString(title)+"</title>\n"+
+            "</head>\n"+
+            "<body>\n"+
+            "<pre>\n"+
+            body+
+            "</pre>\n"+
+            "</body>\n"+
+            "</html>\n"
+    return []byte(page)
+}
+
+/* Get link for item from menu page at selector: relative to the page
+ * if exported, otherwise the item's own URL. Empty if not a link.
+ */
+func (e *exporter) itemHref(selector string, item *client.Item) string {
+    switch {
+        case !item.IsLink():
+            return ""
+        case item.ExternalURL() != "":
+            return item.ExternalURL()
+        case item.Type == gopher.TypeTelnet || item.Type == gopher.TypeTn3270:
+            return "telnet://"+item.Host+":"+item.Port
+        case !e.isExported(item):
+            return item.URL().String()
+    }
+
+    target := path.Clean("/"+item.Selector)
+    if item.Type == gopher.TypeDirectory {
+        target = path.Join(target, "index.html")
+    }
+    rel, err := filepath.Rel(path.Clean("/"+selector), target)
+    if err != nil {
+        return target
+    }
+    return (&url.URL{ Path: filepath.ToSlash(rel) }).String()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "path"
+    "crypto/tls"
+    "github.com/EaterLabs/gophor/gopher"
+    "github.com/EaterLabs/gophor/gopher/client"
+)
+
+/* gophor fetch [flags] <gopher URL>
+ * Fetch a URL, printing menus and text or saving binary files.
+ */
+func runFetch(args []string) int {
+    flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+    flags.Usage = func() {
+        fmt.Fprintf(flags.Output(), "Usage: gophor fetch [flags] gopher://host[:port]/<type><selector>\n")
+        flags.PrintDefaults()
+    }
+    output   := flags.String("o", "", "Save response to this file ('-' for stdout). Binary items are otherwise saved under their selector's name, never overwriting an existing file.")
+    query    := flags.String("query", "", "Search query sent with the selector (type 7 items).")
+    plus     := flags.String("plus", "", "Send Gopher+ request string, e.g. '+' for item data or '!' for attributes.")
+    raw      := flags.Bool("raw", false, "Print menus as received rather than formatted.")
+    timeout  := flags.Duration("timeout", client.DefaultTimeout, "Change request timeout.")
+    insecure := flags.Bool("insecure", false, "Skip TLS certificate verification for gophers:// URLs.")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        flags.Usage()
+        return 2
+    }
+
+    u, err := client.ParseURL(flags.Arg(0))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+        return 2
+    }
+    if *query != "" {
+        u.Query = *query
+    }
+    if *plus != "" {
+        u.Plus = *plus
+    }
+
+    c := &client.Client{ Timeout: *timeout, TLSConfig: &tls.Config{ InsecureSkipVerify: *insecure } }
+    response, err := c.Fetch(u)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error fetching %s: %s\n", u, err.Error())
+        return 1
+    }
+
+    if message := response.ServerError(); message != "" {
+        fmt.Fprintf(os.Stderr, "Server error for %s: %s\n", u, message)
+        return 1
+    }
+
+    /* Save to file if requested, or if binary and no output given. Names
+     * chosen from the selector never overwrite an existing file.
+     */
+    outPath, overwrite := *output, true
+    if outPath == "" && !isTextType(u.Type) {
+        outPath, overwrite = path.Base("/"+u.Selector), false
+        if outPath == "/" {
+            outPath = "index"
+        }
+    }
+    if outPath != "" && outPath != "-" {
+        err = saveResponse(outPath, response.Body, overwrite)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error saving to %s: %s\n", outPath, err.Error())
+            return 1
+        }
+        fmt.Fprintf(os.Stderr, "Saved %d bytes to %s\n", len(response.Body), outPath)
+        return 0
+    }
+
+    switch {
+        case outPath == "-" || *raw:
+            os.Stdout.Write(response.Body)
+        case u.Type == gopher.TypeDirectory || u.Type == gopher.TypeSearch:
+            printMenu(response.Menu())
+        default:
+            fmt.Print(response.Text())
+    }
+    return 0
+}
+
+/* Save body to file, failing if it exists unless overwrite is set */
+func saveResponse(outPath string, body []byte, overwrite bool) error {
+    flags := os.O_WRONLY|os.O_CREATE|os.O_TRUNC
+    if !overwrite {
+        flags = os.O_WRONLY|os.O_CREATE|os.O_EXCL
+    }
+
+    fd, err := os.OpenFile(outPath, flags, 0644)
+    if err != nil {
+        if os.IsExist(err) {
+            return fmt.Errorf("%s already exists, use -o to name the file to save to", outPath)
+        }
+        return err
+    }
+
+    _, err = fd.Write(body)
+    if closeErr := fd.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+/* Print menu items, with the URL of each link */
+func printMenu(items []*client.Item) {
+    for _, item := range items {
+        switch {
+            case !item.IsLink():
+                fmt.Printf("      %s\n", item.Display)
+            case item.ExternalURL() != "":
+                fmt.Printf("[%c]   %s <%s>\n", item.Type, item.Display, item.ExternalURL())
+            default:
+                fmt.Printf("[%c]   %s <%s>\n", item.Type, item.Display, item.URL())
+        }
+    }
+}
+
+/* Check if items of type are text to be printed, rather than saved */
+func isTextType(t gopher.ItemType) bool {
+    switch t {
+        case gopher.TypeFile, gopher.TypeDirectory, gopher.TypeError, gopher.TypeSearch,
+             gopher.TypeCalendar, gopher.TypeHtml, gopher.TypeMarkup, gopher.TypeMail, gopher.TypeXml:
+            return true
+        default:
+            return false
+    }
+}
@@ -0,0 +1,401 @@
+package gopher
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "bytes"
+    "io/fs"
+    "path"
+    "time"
+    "strings"
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "compress/bzip2"
+)
+
+/* Archive formats that can be browsed, checked longest suffix first */
+var archiveExts = []string{ ".tar.bz2", ".tar.gz", ".tbz2", ".tgz", ".tar", ".zip" }
+
+/* ArchiveMember:
+ * A single file or directory within an archive. Implements
+ * os.FileInfo so members can be sorted and formatted exactly
+ * like entries of a directory on disk.
+ */
+type ArchiveMember struct {
+    Path    string
+    size    int64
+    modTime time.Time
+    isDir   bool
+}
+
+func (m *ArchiveMember) Name() string       { return path.Base(m.Path) }
+func (m *ArchiveMember) Size() int64        { return m.size }
+func (m *ArchiveMember) ModTime() time.Time { return m.modTime }
+func (m *ArchiveMember) IsDir() bool        { return m.isDir }
+func (m *ArchiveMember) Sys() interface{}   { return nil }
+func (m *ArchiveMember) Mode() os.FileMode {
+    if m.isDir {
+        return os.ModeDir | 0555
+    }
+    return 0444
+}
+
+/* ArchiveContents:
+ * Implementation of FileContents holding the index of an
+ * archive's members, mapped by path and by parent directory.
+ * Cached under a view key of the archive's path so it doesn't
+ * clash with the archive itself being cached for download.
+ * Render() is unused, menus are generated from the index.
+ */
+type ArchiveContents struct {
+    path     string
+    members  map[string]*ArchiveMember
+    children map[string][]os.FileInfo
+    config   *ServerConfig
+}
+
+func (ac *ArchiveContents) Render(request *FileSystemRequest) []byte {
+    /* Never served */
+    return nil
+}
+
+func (ac *ArchiveContents) Load() *GophorError {
+    ac.members  = make(map[string]*ArchiveMember)
+    ac.children = make(map[string][]os.FileInfo)
+
+    return ac.config.walkArchive(ac.path, func(name string, info os.FileInfo, reader io.Reader) bool {
+        ac.add(&ArchiveMember{ name, info.Size(), info.ModTime(), info.IsDir() })
+        return true
+    })
+}
+
+func (ac *ArchiveContents) Clear() {
+    ac.members  = nil
+    ac.children = nil
+}
+
+/* Add member to index, along with any parent directories not stored in the archive */
+func (ac *ArchiveContents) add(member *ArchiveMember) {
+    if existing, ok := ac.members[member.Path]; ok {
+        if member.isDir {
+            /* Replace implied directory details with those stored */
+            existing.modTime = member.modTime
+        }
+        return
+    }
+    ac.members[member.Path] = member
+
+    parent := path.Dir(member.Path)
+    if parent == "." {
+        parent = ""
+    } else {
+        ac.add(&ArchiveMember{ parent, 0, member.modTime, true })
+    }
+    ac.children[parent] = append(ac.children[parent], member)
+}
+
+/* Check if file at path is a browsable archive */
+func isArchiveFile(filePath string) bool {
+    return archiveExt(filePath) != ""
+}
+
+/* Get browsable archive extension of path, empty if none */
+func archiveExt(filePath string) string {
+    lower := strings.ToLower(filePath)
+    for _, ext := range archiveExts {
+        if strings.HasSuffix(lower, ext) {
+            return ext
+        }
+    }
+    return ""
+}
+
+/* Split request path into the path of an archive on disk and the member
+ * path within, e.g. '/files/src.tar.gz/dir/file.c' -> '/files/src.tar.gz',
+ * 'dir/file.c'. The archive root is requested with ArchiveListQuery appended.
+ */
+func (config *ServerConfig) splitArchivePath(requestPath string) (string, string, bool) {
+    if strings.HasSuffix(requestPath, ArchiveListQuery) {
+        archivePath := strings.TrimSuffix(requestPath, ArchiveListQuery)
+        if isArchiveFile(archivePath) && config.isRegularFile(archivePath) {
+            return archivePath, "", true
+        }
+        return "", "", false
+    }
+
+    for i := 1; i < len(requestPath); i += 1 {
+        if requestPath[i] != '/' || !isArchiveFile(requestPath[:i]) {
+            continue
+        }
+        if config.isRegularFile(requestPath[:i]) {
+            return requestPath[:i], requestPath[i+1:], true
+        }
+    }
+    return "", "", false
+}
+
+func (config *ServerConfig) isRegularFile(filePath string) bool {
+    stat, err := config.statContent(filePath)
+    return err == nil && stat.Mode() & os.ModeType == 0
+}
+
+/* Clean archive member name into a relative path, empty if it escapes the archive */
+func cleanArchiveName(name string) string {
+    name = path.Clean("/"+strings.Replace(name, "\\", "/", -1))[1:]
+    if name == "" || strings.HasPrefix(name, "../") {
+        return ""
+    }
+    return name
+}
+
+/* Walk through members of archive at path, calling iterator with each
+ * member's cleaned path, info and a reader for its contents (nil for
+ * directories). Stops early if iterator returns false.
+ */
+func (config *ServerConfig) walkArchive(archivePath string, iterator func(string, os.FileInfo, io.Reader) bool) *GophorError {
+    fd, err := config.openContent(archivePath)
+    if err != nil {
+        return &GophorError{ FileOpenErr, err }
+    }
+    defer fd.Close()
+
+    if archiveExt(archivePath) == ".zip" {
+        readerAt, size, gophorErr := contentReaderAt(fd)
+        if gophorErr != nil {
+            return gophorErr
+        }
+
+        zipReader, err := zip.NewReader(readerAt, size)
+        if err != nil {
+            return &GophorError{ FileReadErr, err }
+        }
+
+        for _, file := range zipReader.File {
+            name := cleanArchiveName(file.Name)
+            if name == "" {
+                continue
+            }
+
+            if file.FileInfo().IsDir() {
+                if !iterator(name, file.FileInfo(), nil) {
+                    break
+                }
+                continue
+            }
+
+            /* Only decompressed if actually read */
+            reader := &zipMemberReader{ file, nil }
+            keepGoing := iterator(name, file.FileInfo(), reader)
+            reader.Close()
+            if !keepGoing {
+                break
+            }
+        }
+        return nil
+    }
+
+    /* Wrap in decompressor if needed */
+    var reader io.Reader = fd
+    switch archiveExt(archivePath) {
+        case ".tar.gz", ".tgz":
+            gzipReader, err := gzip.NewReader(fd)
+            if err != nil {
+                return &GophorError{ FileReadErr, err }
+            }
+            defer gzipReader.Close()
+            reader = gzipReader
+
+        case ".tar.bz2", ".tbz2":
+            reader = bzip2.NewReader(fd)
+    }
+
+    tarReader := tar.NewReader(reader)
+    for {
+        header, err := tarReader.Next()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return &GophorError{ FileReadErr, err }
+        }
+
+        /* Only regular files and directories */
+        name := cleanArchiveName(header.Name)
+        if name == "" || (header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir) {
+            continue
+        }
+
+        if !iterator(name, header.FileInfo(), tarReader) {
+            break
+        }
+    }
+    return nil
+}
+
+/* Get random access to open content file, as zip requires. Sources whose
+ * files don't support this (e.g. compressed ones) are read into memory.
+ */
+func contentReaderAt(fd fs.File) (io.ReaderAt, int64, *GophorError) {
+    stat, err := fd.Stat()
+    if err != nil {
+        return nil, 0, &GophorError{ FileStatErr, err }
+    }
+
+    if readerAt, ok := fd.(io.ReaderAt); ok {
+        return readerAt, stat.Size(), nil
+    }
+
+    contents, gophorErr := bufferedReadFrom(fd)
+    if gophorErr != nil {
+        return nil, 0, gophorErr
+    }
+    return bytes.NewReader(contents), int64(len(contents)), nil
+}
+
+/* zipMemberReader:
+ * Reader for a zip member that only opens (and so starts
+ * decompressing) the member on first read.
+ */
+type zipMemberReader struct {
+    file       *zip.File
+    readCloser io.ReadCloser
+}
+
+func (r *zipMemberReader) Read(b []byte) (int, error) {
+    if r.readCloser == nil {
+        var err error
+        r.readCloser, err = r.file.Open()
+        if err != nil {
+            return 0, err
+        }
+    }
+    return r.readCloser.Read(b)
+}
+
+func (r *zipMemberReader) Close() {
+    if r.readCloser != nil {
+        r.readCloser.Close()
+    }
+}
+
+/* Fetch cached index of archive at path, calling use with it read-locked */
+func (fs *FileSystem) fetchArchive(request *FileSystemRequest, archivePath string, use func(*ArchiveContents)) *GophorError {
+    return fs.fetchCached(&FileSystemRequest{ cacheViewKey(archivePath, "archive"), "", request.Host, request.Id, request.config },
+        func(path string) FileContents {
+            return &ArchiveContents{ path, nil, nil, fs.config }
+        },
+        func(file *File) {
+            use(file.contents.(*ArchiveContents))
+        },
+    )
+}
+
+/* Serve member of archive, either returning a generated menu for directories
+ * or streaming the member's contents to w (returning nil output)
+ */
+func (fs *FileSystem) HandleArchiveRequest(w io.Writer, request *FileSystemRequest, dirConfig *DirConfig, archivePath, memberPath string) ([]byte, *GophorError) {
+    memberPath = cleanArchiveName(memberPath)
+
+    var member *ArchiveMember
+    var children []os.FileInfo
+    gophorErr := fs.fetchArchive(request, archivePath, func(archive *ArchiveContents) {
+        member   = archive.members[memberPath]
+        children = append([]os.FileInfo{}, archive.children[memberPath]...)
+    })
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+
+    /* Regular member, read it out of the archive */
+    if memberPath != "" && member == nil {
+        return nil, &GophorError{ FileStatErr, nil }
+    } else if member != nil && !member.isDir {
+        request.Trace("Serving archive member: %s in %s\n", memberPath, archivePath)
+        return nil, fs.copyArchiveMember(w, archivePath, memberPath)
+    }
+
+    request.Trace("Serving archive listing: %s in %s\n", memberPath, archivePath)
+
+    /* Sort the members by requested order */
+    sortFiles(children, dirConfig.Sort, dirConfig.DirsFirst)
+
+    archiveSelector := fs.config.UserDirs.Selector(archivePath)
+    selector := archiveSelector+ArchiveListQuery
+    parentSelector := path.Dir(archiveSelector)
+    if memberPath != "" {
+        selector = archiveSelector+"/"+memberPath
+        parentSelector = archiveSelector+"/"+path.Dir(memberPath)
+        if path.Dir(memberPath) == "." {
+            parentSelector = archiveSelector+ArchiveListQuery
+        }
+    }
+
+    /* Add a title, space and 'back' entry, as in directory listings */
+    contents := fs.config.buildLine(TypeInfo, "[ "+request.Host.Name+selector+" ]", "TITLE", NullHost, NullPort)
+    contents = append(contents, fs.config.buildInfoLine("")...)
+    contents = append(contents, fs.config.buildLine(TypeDirectory, "..", parentSelector, request.Host.Name, request.Host.Port)...)
+
+    for _, child := range children {
+        itemType := TypeDirectory
+        if !child.IsDir() {
+            itemType = fs.config.getItemType(child.Name())
+        }
+        contents = append(contents, fs.config.buildLine(itemType, fs.config.formatListName(child, dirConfig.Style), archiveSelector+"/"+child.(*ArchiveMember).Path, request.Host.Name, request.Host.Port)...)
+    }
+
+    /* Append footer text (contains last line) */
+    if dirConfig.Footer != nil {
+        contents = append(contents, dirConfig.Footer...)
+    } else {
+        contents = append(contents, fs.config.FooterText...)
+    }
+    return contents, nil
+}
+
+/* Copy contents of archive member to w, up to the archive member size limit.
+ * Members claiming to be over the limit are refused before anything is
+ * written, those found to be over it while decompressing are cut short.
+ */
+func (fs *FileSystem) copyArchiveMember(w io.Writer, archivePath, memberPath string) *GophorError {
+    var copyErr *GophorError
+    gophorErr := fs.config.walkArchive(archivePath, func(name string, info os.FileInfo, reader io.Reader) bool {
+        if name != memberPath || reader == nil {
+            return true
+        }
+
+        if info.Size() > fs.config.ArchiveMaxSize {
+            copyErr = &GophorError{ ArchiveLimitErr, fmt.Errorf("%s is %d bytes, more than %d", memberPath, info.Size(), fs.config.ArchiveMaxSize) }
+            return false
+        }
+
+        /* Read one byte past the limit to tell if it was reached */
+        count, err := io.Copy(w, io.LimitReader(reader, fs.config.ArchiveMaxSize+1))
+        if err != nil {
+            copyErr = &GophorError{ FileReadErr, err }
+        } else if count > fs.config.ArchiveMaxSize {
+            copyErr = &GophorError{ ArchiveLimitErr, fmt.Errorf("%s is more than %d bytes", memberPath, fs.config.ArchiveMaxSize) }
+        }
+        return false
+    })
+    if gophorErr != nil {
+        return gophorErr
+    }
+    return copyErr
+}
+
+/* browseFileInfo:
+ * Wraps an archive's file info so it is listed as a directory
+ * with a trailing '/', for the line browsing the archive.
+ */
+type browseFileInfo struct {
+    os.FileInfo
+}
+
+func (b *browseFileInfo) Name() string { return b.FileInfo.Name()+"/" }
+func (b *browseFileInfo) IsDir() bool  { return true }
+
+/* Build menu line for browsing archive at selector, listed alongside the archive itself */
+func (config *ServerConfig) buildArchiveBrowseLine(file os.FileInfo, selector string, dirConfig *DirConfig, connHost *ConnHost) []byte {
+    return config.buildLine(TypeDirectory, config.formatListName(&browseFileInfo{ file }, dirConfig.Style), selector+ArchiveListQuery, connHost.Name, connHost.Port)
+}
@@ -0,0 +1,384 @@
+package gopher
+
+import (
+    "os"
+    "fmt"
+    "path"
+    "strconv"
+    "strings"
+    "io/fs"
+)
+
+/* Problem:
+ * A problem found checking the server's content, at a line
+ * of the file at Path (zero if about the file as a whole).
+ */
+type Problem struct {
+    Path    string
+    Line    int
+    Message string
+}
+
+func (p *Problem) String() string {
+    if p.Line == 0 {
+        return p.Path+": "+p.Message
+    }
+    return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Message)
+}
+
+/* Link:
+ * A gophermap item at Line of the gophermap at Path pointing
+ * off this server, either to another gopher server or, for
+ * 'URL:' selectors, anywhere at all.
+ */
+type Link struct {
+    Path     string
+    Line     int
+    Type     ItemType
+    Selector string
+    Host     string
+    Port     string
+}
+
+/* Get target of 'URL:' selector, empty if a gopher link */
+func (l *Link) ExternalURL() string {
+    if !strings.HasPrefix(l.Selector, "URL:") {
+        return ""
+    }
+    return strings.TrimPrefix(l.Selector, "URL:")
+}
+
+/* contentChecker:
+ * Holds state while checking gophermaps: those whose lines have
+ * already been checked (whether found by walking the root, or
+ * included), problems (each reported once) and external links found.
+ */
+type contentChecker struct {
+    server   *Server
+    checked  map[string]bool
+    reported map[string]bool
+    problems []*Problem
+    links    []*Link
+}
+
+/* Check every gophermap under the root, walking them as readGophermap()
+ * does, returning the problems found in walk order. Local selectors are
+ * checked as a worker would serve them (after any rewrite rules), so
+ * generated selectors count too. Gophermaps in user directories are
+ * not walked.
+ */
+func (s *Server) Check() []*Problem {
+    return s.checkContent().problems
+}
+
+/* Get all links off this server from gophermaps under the root, in walk
+ * order, for checking elsewhere. Telnet items aren't included.
+ */
+func (s *Server) ExternalLinks() []*Link {
+    return s.checkContent().links
+}
+
+func (s *Server) checkContent() *contentChecker {
+    s.cachePolicyFiles()
+
+    c := &contentChecker{ s, make(map[string]bool), make(map[string]bool), make([]*Problem, 0), make([]*Link, 0) }
+    fs.WalkDir(s.Config.FileSystem.Source, ".", func(sourceFilePath string, entry fs.DirEntry, err error) error {
+        filePath := sanitizePath(sourceFilePath)
+        if err != nil {
+            c.report(filePath, 0, "%s", err.Error())
+            return nil
+        }
+
+        /* Restricted files are never served, so don't matter */
+        if filePath != "/" && s.Config.isRestrictedFile(entry.Name()) {
+            if entry.IsDir() {
+                return fs.SkipDir
+            }
+            return nil
+        }
+
+        if len(filePath) > MaxSelectorLen {
+            c.report(filePath, 0, "selector longer than %d bytes, listed as %q", MaxSelectorLen, SelectorErrorStr)
+        }
+
+        if !entry.IsDir() && entry.Name() == GophermapFileStr {
+            c.checkGophermap(filePath)
+        }
+        return nil
+    })
+
+    return c
+}
+
+func (c *contentChecker) report(filePath string, lineNo int, format string, args ...interface{}) {
+    problem := &Problem{ filePath, lineNo, fmt.Sprintf(format, args...) }
+    if c.reported[problem.String()] {
+        return
+    }
+    c.reported[problem.String()] = true
+    c.problems = append(c.problems, problem)
+}
+
+/* Check gophermap at path, walked from the top as when it is served */
+func (c *contentChecker) checkGophermap(gophermapPath string) {
+    gophorErr := c.server.Config.walkGophermap(gophermapPath, []string{ gophermapPath }, c.newGophermapChecker(gophermapPath))
+    if gophorErr != nil {
+        c.report(gophermapPath, 0, "error reading gophermap: %s", gophorErr.Error())
+    }
+}
+
+/* gophermapChecker:
+ * GophermapVisitor checking a single gophermap. Included gophermaps
+ * are walked wherever they are included, as include cycles and depth
+ * depend on the chain of includes, but their lines are only checked
+ * the first time they are seen.
+ */
+type gophermapChecker struct {
+    checker     *contentChecker
+    path        string
+    checkLines  bool
+    titleLineNo int
+}
+
+func (c *contentChecker) newGophermapChecker(gophermapPath string) *gophermapChecker {
+    checkLines := !c.checked[gophermapPath]
+    c.checked[gophermapPath] = true
+    return &gophermapChecker{ c, gophermapPath, checkLines, 0 }
+}
+
+func (g *gophermapChecker) Line(lineNo int, lineType ItemType, line string) bool {
+    switch lineType {
+        case TypeEnd, TypeEndBeginList:
+            /* Nothing after these is read */
+            return false
+    }
+    if !g.checkLines {
+        return true
+    }
+
+    switch lineType {
+        case TypeInfoNotStated:
+            g.checker.checkName(g.path, lineNo, line)
+
+        case TypeTitle:
+            if g.titleLineNo != 0 {
+                g.checker.report(g.path, lineNo, "duplicate title line ignored, title already set on line %d", g.titleLineNo)
+            } else {
+                g.titleLineNo = lineNo
+                g.checker.checkName(g.path, lineNo, line[1:])
+            }
+
+        case TypeExec:
+            g.checker.report(g.path, lineNo, "inline shell commands not supported")
+
+        case TypeComment, TypeHiddenFile, TypeUserList:
+            break
+
+        default:
+            g.checker.checkMenuLine(g.path, lineNo, line)
+    }
+
+    return true
+}
+
+func (g *gophermapChecker) IncludeFile(lineNo int, includePath string) {
+    if _, err := g.checker.server.Config.statContent(includePath); err != nil {
+        g.checker.report(g.path, lineNo, "included file not found: %s", includePath)
+    }
+}
+
+func (g *gophermapChecker) IncludeGophermap(lineNo int, includePath string, walk func(GophermapVisitor) *GophorError) {
+    gophorErr := walk(g.checker.newGophermapChecker(includePath))
+    if gophorErr == nil {
+        return
+    }
+
+    if _, err := g.checker.server.Config.statContent(includePath); err != nil {
+        g.checker.report(g.path, lineNo, "included file not found: %s", includePath)
+    } else {
+        g.checker.report(g.path, lineNo, "error reading subgophermap %s: %s", includePath, gophorErr.Error())
+    }
+}
+
+func (g *gophermapChecker) IncludeError(lineNo int, reason string) {
+    g.checker.report(g.path, lineNo, "%s", reason)
+}
+
+/* Check an info or title line fits the page width, as buildLine() would truncate it */
+func (c *contentChecker) checkName(gophermapPath string, lineNo int, name string) {
+    if stringWidth(name) > c.server.Config.PageWidth {
+        c.report(gophermapPath, lineNo, "text wider than page width %d, will be truncated: %q", c.server.Config.PageWidth, truncateName(name, c.server.Config.PageWidth))
+    }
+}
+
+/* Check a menu line's fields, as completed by completeGophermapLine() */
+func (c *contentChecker) checkMenuLine(gophermapPath string, lineNo int, line string) {
+    fields := strings.Split(line, Tab)
+    if len(fields) > 5 || (len(fields) == 5 && fields[4] != "+") {
+        c.report(gophermapPath, lineNo, "wrong number of fields: %d, expected type+name, selector, host, port (and optional '+')", len(fields))
+        return
+    }
+
+    /* Info and error lines aren't selectable, nothing else to check */
+    itemType := ItemType(line[0])
+    if itemType == TypeInfo || itemType == TypeError {
+        return
+    }
+
+    fields = strings.Split(c.server.Config.completeGophermapLine(line, gophermapPath), Tab)
+    selector, host, port := fields[1], fields[2], fields[3]
+
+    if len(selector) > MaxSelectorLen {
+        c.report(gophermapPath, lineNo, "selector longer than %d bytes", MaxSelectorLen)
+        return
+    }
+
+    if port != ReplaceStrPort {
+        if _, err := strconv.Atoi(port); err != nil {
+            c.report(gophermapPath, lineNo, "invalid port: %s", port)
+            return
+        }
+    }
+
+    /* Telnet selectors are login names, not files */
+    if itemType == TypeTelnet || itemType == TypeTn3270 {
+        return
+    }
+
+    /* Only local selectors can be checked here, others are kept for checking elsewhere */
+    if strings.HasPrefix(selector, "URL:") || !c.server.isLocalHost(host, port) {
+        c.links = append(c.links, &Link{ gophermapPath, lineNo, itemType, selector, host, port })
+        return
+    }
+    if !c.server.selectorExists(selector) {
+        c.report(gophermapPath, lineNo, "broken local selector: %s", selector)
+    }
+}
+
+/* Check if host and port (from a completed gophermap line) refer to this server */
+func (s *Server) isLocalHost(host, port string) bool {
+    if host == ReplaceStrHostname {
+        return true
+    }
+    return host == s.options.Hostname && (port == ReplaceStrPort || port == strconv.Itoa(s.options.Port))
+}
+
+/* Check if selector can be served, following rewrite rules and user
+ * directories as a worker would. Selectors are looked up rather than
+ * requested, so generated ones aren't built just to be thrown away,
+ * except with a user supplied handler which is requested as a client would.
+ */
+func (s *Server) selectorExists(selector string) bool {
+    action, requestPath := s.Config.applyRewriteRules(sanitizePath(selector))
+    switch action {
+        case RewriteActionRedirect:
+            return true
+        case RewriteActionGone:
+            return false
+        default:
+            /* Rewritten or no matching rule */
+    }
+
+    if s.options.Handler != nil {
+        w := &discardResponseWriter{ nil }
+        s.Handler.Serve(w, &Request{ requestPath, "", &ConnHost{ s.options.Hostname, strconv.Itoa(s.options.Port) }, nil, 0, s.Config })
+        return w.err == nil
+    }
+
+    if s.Config.Search != nil && requestPath == s.Config.Search.Selector {
+        return true
+    }
+    return s.Config.FileSystem.selectorExists(s.Config.UserDirs.Resolve(requestPath))
+}
+
+/* Check if request path would be served, routing as buildResponse() does
+ * but recognising generated selectors (Markdown source, phlog pages, feeds,
+ * archive members and directory archives) from their paths
+ */
+func (fs *FileSystem) selectorExists(requestPath string) bool {
+    request := &FileSystemRequest{ requestPath, "", nil, 0, fs.config }
+    if path.Base(requestPath) == DirConfigFileStr {
+        return false
+    }
+
+    if fs.config.RenderMarkdown && strings.HasSuffix(requestPath, MarkdownRawSuffix) {
+        if rawPath := strings.TrimSuffix(requestPath, MarkdownRawSuffix); isMarkdownFile(rawPath) {
+            requestPath = rawPath
+        }
+    }
+
+    /* Phlog pages and feeds are only there if the directory's config enables them */
+    wantPhlog, wantFeed := false, false
+    if dirPath, _, _, ok := parsePhlogQuery(requestPath); ok {
+        requestPath, wantPhlog = dirPath, true
+    }
+    if path.Base(requestPath) == FeedFileStr {
+        if _, err := fs.config.statContent(requestPath); err != nil {
+            requestPath, wantFeed = path.Dir(requestPath), true
+        }
+    }
+
+    stat, err := fs.config.statContent(requestPath)
+    if err != nil {
+        /* Member within an archive, checked against the archive's index */
+        if archivePath, memberPath, ok := fs.config.splitArchivePath(requestPath); ok {
+            if fs.GetDirConfig(request, path.Dir(archivePath)).Deny {
+                return false
+            }
+
+            memberPath = cleanArchiveName(memberPath)
+            found := memberPath == ""
+            gophorErr := fs.fetchArchive(request, archivePath, func(archive *ArchiveContents) {
+                found = found || archive.members[memberPath] != nil
+            })
+            return gophorErr == nil && found
+        }
+
+        if dirPath, ok := fs.config.splitDirArchivePath(requestPath); ok {
+            return !fs.GetDirConfig(request, dirPath).Deny
+        }
+
+        /* Generated files are only in the cache, e.g. caps.txt */
+        fs.CacheMutex.RLock()
+        defer fs.CacheMutex.RUnlock()
+        return fs.CacheMap.Get(requestPath) != nil
+    }
+
+    isDir := stat.IsDir()
+    if !isDir && stat.Mode() & os.ModeType != 0 {
+        return false
+    }
+
+    dirPath := requestPath
+    if !isDir {
+        dirPath = path.Dir(requestPath)
+    }
+    dirConfig := fs.GetDirConfig(request, dirPath)
+    switch {
+        case dirConfig.Deny:
+            return false
+        case wantPhlog:
+            return isDir && dirConfig.Phlog
+        case wantFeed:
+            return isDir && dirConfig.Feed
+        default:
+            return true
+    }
+}
+
+/* discardResponseWriter:
+ * ResponseWriter that only keeps the first error written.
+ */
+type discardResponseWriter struct {
+    err *GophorError
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+    return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteError(gophorErr *GophorError) {
+    if w.err == nil {
+        w.err = gophorErr
+    }
+}
@@ -0,0 +1,53 @@
+package gopher
+
+import (
+    "os"
+    "testing"
+    "path/filepath"
+)
+
+/* Write files, keyed by slash separated path, under dir */
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+    for name, contents := range files {
+        filePath := filepath.Join(dir, filepath.FromSlash(name))
+        err := os.MkdirAll(filepath.Dir(filePath), 0755)
+        if err == nil {
+            err = os.WriteFile(filePath, []byte(contents), 0644)
+        }
+        if err != nil {
+            t.Fatalf("Error writing %s: %s", name, err.Error())
+        }
+    }
+}
+
+/* A gophermap first included near the top is still checked for
+ * problems that only show up when it is included further down
+ */
+func TestCheckIncludeDepth(t *testing.T) {
+    options := DefaultServerOptions()
+    options.LogType       = 1
+    options.CacheDisabled = true
+    options.RootDir       = t.TempDir()
+    writeTestFiles(t, options.RootDir, map[string]string{
+        "gophermap":    "=/d/gophermap\n=/c1/gophermap\n",
+        "c1/gophermap": "=/c2/gophermap\n",
+        "c2/gophermap": "=/c3/gophermap\n",
+        "c3/gophermap": "=/c4/gophermap\n",
+        "c4/gophermap": "=/c5/gophermap\n",
+        "c5/gophermap": "=/c6/gophermap\n",
+        "c6/gophermap": "=/d/gophermap\n",
+        "d/gophermap":  "=/e/gophermap\n",
+        "e/gophermap":  "Leaf\n",
+    })
+
+    server, gophorErr := NewServer(options)
+    if gophorErr != nil {
+        t.Fatalf("Error creating server: %s", gophorErr.Error())
+    }
+
+    want := "/d/gophermap:1: max include depth 8 reached including /e/gophermap"
+    problems := server.Check()
+    if len(problems) != 1 || problems[0].String() != want {
+        t.Errorf("Problems:\ngot:  %v\nwant: [%s]", problems, want)
+    }
+}
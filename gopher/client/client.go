@@ -0,0 +1,196 @@
+package client
+
+import (
+    "io"
+    "fmt"
+    "net"
+    "time"
+    "bytes"
+    "errors"
+    "strconv"
+    "strings"
+    "io/ioutil"
+    "crypto/tls"
+    "github.com/EaterLabs/gophor/gopher"
+)
+
+const (
+    DefaultPort    = "70"
+    DefaultTimeout = 30 * time.Second
+)
+
+/* Client:
+ * Fetches gopher URLs over plain TCP, or TLS for 'gophers' URLs.
+ * Timeout covers the whole request, MaxSize limits the response
+ * (zero meaning no limit for either).
+ */
+type Client struct {
+    Timeout   time.Duration
+    MaxSize   int64
+    TLSConfig *tls.Config
+}
+
+var DefaultClient = &Client{ DefaultTimeout, 0, nil }
+
+/* Returned (wrapped) by Fetch when a response is larger than MaxSize */
+var ErrResponseTooLarge = errors.New("response too large")
+
+/* Response:
+ * The raw response to a request. Gopher+ responses have their
+ * header already removed from Body.
+ */
+type Response struct {
+    URL  *URL
+    Body []byte
+    Plus bool
+}
+
+/* Fetch URL using the default client */
+func Get(raw string) (*Response, error) {
+    return DefaultClient.Get(raw)
+}
+
+func (c *Client) Get(raw string) (*Response, error) {
+    u, err := ParseURL(raw)
+    if err != nil {
+        return nil, err
+    }
+    return c.Fetch(u)
+}
+
+func (c *Client) Fetch(u *URL) (*Response, error) {
+    addr := net.JoinHostPort(u.Host, u.Port)
+    dialer := &net.Dialer{ Timeout: c.Timeout }
+
+    var conn net.Conn
+    var err error
+    if u.TLS {
+        conn, err = tls.DialWithDialer(dialer, "tcp", addr, c.TLSConfig)
+    } else {
+        conn, err = dialer.Dial("tcp", addr)
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+
+    if c.Timeout > 0 {
+        conn.SetDeadline(time.Now().Add(c.Timeout))
+    }
+
+    _, err = conn.Write([]byte(u.RequestLine()))
+    if err != nil {
+        return nil, err
+    }
+
+    /* Read until the server closes the connection */
+    var reader io.Reader = conn
+    if c.MaxSize > 0 {
+        reader = io.LimitReader(conn, c.MaxSize+1)
+    }
+    body, err := ioutil.ReadAll(reader)
+    if err != nil {
+        return nil, err
+    } else if c.MaxSize > 0 && int64(len(body)) > c.MaxSize {
+        return nil, fmt.Errorf("response from %s exceeds %d bytes: %w", u, c.MaxSize, ErrResponseTooLarge)
+    }
+
+    response := &Response{ u, body, false }
+    if u.Plus != "" {
+        response.Body, response.Plus, err = parsePlusResponse(body)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return response, nil
+}
+
+/* Parse response body as a menu */
+func (r *Response) Menu() []*Item {
+    return ParseMenu(r.Body)
+}
+
+/* Get body as text, with any terminating '.' line removed and
+ * lines beginning '..' unescaped
+ */
+func (r *Response) Text() string {
+    text := string(r.Body)
+    switch {
+        case text == gopher.LastLine:
+            return ""
+        case strings.HasSuffix(text, gopher.DOSLineEnd+gopher.LastLine):
+            text = strings.TrimSuffix(text, gopher.LastLine)
+        case strings.HasSuffix(text, gopher.UnixLineEnd+gopher.End+gopher.UnixLineEnd):
+            text = strings.TrimSuffix(text, gopher.End+gopher.UnixLineEnd)
+    }
+    text = strings.Replace(text, "\n..", "\n.", -1)
+    if strings.HasPrefix(text, "..") {
+        text = text[1:]
+    }
+    return text
+}
+
+/* Get message of the error response the server sent instead of the
+ * requested item (a menu holding a single type 3 line), empty if none
+ */
+func (r *Response) ServerError() string {
+    if len(r.Body) == 0 || gopher.ItemType(r.Body[0]) != gopher.TypeError {
+        return ""
+    }
+
+    /* Must look like a menu line, or be a lone line followed by the
+     * last line (as some servers send), so files starting '3' aren't mistaken
+     */
+    lines := strings.Split(strings.TrimSuffix(string(r.Body), gopher.DOSLineEnd), gopher.DOSLineEnd)
+    if strings.Count(lines[0], "\t") < 3 && (len(lines) != 2 || lines[1] != gopher.End) {
+        return ""
+    }
+
+    items := r.Menu()
+    if len(items) != 1 {
+        return ""
+    }
+    return items[0].Display
+}
+
+/* Strip Gopher+ header from response: '+<length>', '+-1' (terminated
+ * by '.' line) or '+-2' (until close). Headers beginning '--' carry an
+ * error. Responses without a header are returned as-is.
+ */
+func parsePlusResponse(data []byte) ([]byte, bool, error) {
+    i := bytes.Index(data, []byte(gopher.DOSLineEnd))
+    if i < 0 || len(data) < 2 || (data[0] != '+' && !bytes.HasPrefix(data, []byte("--"))) {
+        return data, false, nil
+    }
+    header, body := string(data[:i]), data[i+len(gopher.DOSLineEnd):]
+
+    if strings.HasPrefix(header, "--") {
+        /* Error code and contact, then the error message */
+        message := strings.TrimSpace(string(body))
+        message = strings.TrimSuffix(message, gopher.End)
+        return nil, true, errors.New("gopher+ error: "+strings.TrimSpace(strings.Replace(message, gopher.DOSLineEnd, " ", -1)))
+    }
+
+    fields := strings.Fields(header[1:])
+    if len(fields) == 0 {
+        return data, false, nil
+    }
+    length, err := strconv.Atoi(fields[0])
+    if err != nil {
+        /* Not a gopher+ header after all */
+        return data, false, nil
+    }
+
+    switch {
+        case length == -1:
+            return bytes.TrimSuffix(body, []byte(gopher.LastLine)), true, nil
+        case length == -2:
+            return body, true, nil
+        case length < 0:
+            return nil, true, fmt.Errorf("invalid gopher+ length: %d", length)
+        case len(body) < length:
+            return nil, true, fmt.Errorf("gopher+ response truncated: %d of %d bytes", len(body), length)
+        default:
+            return body[:length], true, nil
+    }
+}
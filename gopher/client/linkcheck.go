@@ -0,0 +1,153 @@
+package client
+
+import (
+    "sync"
+    "time"
+    "errors"
+    "strings"
+    "net/http"
+    "github.com/EaterLabs/gophor/gopher"
+)
+
+const (
+    DefaultLinkConcurrency = 8
+    LinkCheckMaxSize       = 64 * 1024
+)
+
+/* LinkChecker:
+ * Checks the links found by Server.ExternalLinks() are alive, making
+ * at most Concurrency requests at once and requesting each URL only
+ * once. Gopher URLs are fetched with Client (responses larger than its
+ * MaxSize count as alive, so needn't be read in full), http(s) URLs
+ * with HTTPClient. 'URL:' targets with other schemes are skipped.
+ */
+type LinkChecker struct {
+    Client      *Client
+    HTTPClient  *http.Client
+    Concurrency int
+}
+
+/* LinkResult:
+ * Result of checking a link: the URL requested, whether it could
+ * be checked at all and, if found dead, why.
+ */
+type LinkResult struct {
+    *gopher.Link
+    URL     string
+    Checked bool
+    Err     error
+}
+
+func NewLinkChecker(timeout time.Duration, concurrency int) *LinkChecker {
+    return &LinkChecker{
+        &Client{ timeout, LinkCheckMaxSize, nil },
+        &http.Client{ Timeout: timeout },
+        concurrency,
+    }
+}
+
+/* Check links, returning a result for each in the same order */
+func (lc *LinkChecker) Check(links []*gopher.Link) []*LinkResult {
+    /* Build results, collecting the unique URLs to check */
+    results := make([]*LinkResult, len(links))
+    urls := make([]string, 0)
+    seen := make(map[string]bool)
+    for i, link := range links {
+        raw, ok := linkURL(link)
+        results[i] = &LinkResult{ link, raw, ok, nil }
+        if ok && !seen[raw] {
+            seen[raw] = true
+            urls = append(urls, raw)
+        }
+    }
+
+    concurrency := lc.Concurrency
+    if concurrency < 1 {
+        concurrency = 1
+    }
+
+    /* Check URLs, at most concurrency at once */
+    errs := make(map[string]error)
+    limit := make(chan struct{}, concurrency)
+    mutex := sync.Mutex{}
+    wait := sync.WaitGroup{}
+    for _, raw := range urls {
+        wait.Add(1)
+        limit <- struct{}{}
+        go func(raw string) {
+            defer func() {
+                <-limit
+                wait.Done()
+            }()
+
+            err := lc.CheckURL(raw)
+            mutex.Lock()
+            errs[raw] = err
+            mutex.Unlock()
+        }(raw)
+    }
+    wait.Wait()
+
+    for _, result := range results {
+        if result.Checked {
+            result.Err = errs[result.URL]
+        }
+    }
+    return results
+}
+
+/* Check single URL, returning why it's dead or nil if alive */
+func (lc *LinkChecker) CheckURL(raw string) error {
+    if strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://") {
+        return lc.checkHTTP(raw)
+    }
+
+    u, err := ParseURL(raw)
+    if err != nil {
+        return err
+    }
+    response, err := lc.Client.Fetch(u)
+    if errors.Is(err, ErrResponseTooLarge) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+
+    if message := response.ServerError(); message != "" {
+        return errors.New(message)
+    }
+    return nil
+}
+
+/* Check http(s) URL with a HEAD request, falling back to GET for servers not allowing HEAD */
+func (lc *LinkChecker) checkHTTP(raw string) error {
+    response, err := lc.HTTPClient.Head(raw)
+    if err == nil && (response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented) {
+        response.Body.Close()
+        response, err = lc.HTTPClient.Get(raw)
+    }
+    if err != nil {
+        return err
+    }
+    response.Body.Close()
+
+    if response.StatusCode >= 400 {
+        return errors.New(response.Status)
+    }
+    return nil
+}
+
+/* Get URL to check for link, false if it can't be checked */
+func linkURL(link *gopher.Link) (string, bool) {
+    target := link.ExternalURL()
+    if target == "" {
+        return (&URL{ link.Host, link.Port, link.Type, link.Selector, "", "", false }).String(), true
+    }
+
+    for _, scheme := range []string{ "gopher://", "gophers://", "http://", "https://" } {
+        if strings.HasPrefix(target, scheme) {
+            return target, true
+        }
+    }
+    return target, false
+}
@@ -0,0 +1,34 @@
+package client_test
+
+import (
+    "testing"
+    "github.com/EaterLabs/gophor/gopher"
+    "github.com/EaterLabs/gophor/gopher/client"
+    "github.com/EaterLabs/gophor/gopher/gophertest"
+)
+
+func TestLinkCheckerCheck(t *testing.T) {
+    remote := gophertest.NewServer(t, nil)
+    remote.WriteFile("live.txt", "still here\n")
+
+    links := []*gopher.Link{
+        &gopher.Link{ Path: "/gophermap", Line: 1, Type: gopher.TypeFile, Selector: "/live.txt", Host: remote.Host, Port: remote.Port },
+        &gopher.Link{ Path: "/gophermap", Line: 2, Type: gopher.TypeFile, Selector: "/dead.txt", Host: remote.Host, Port: remote.Port },
+    }
+    results := client.NewLinkChecker(client.DefaultTimeout, client.DefaultLinkConcurrency).Check(links)
+
+    if len(results) != len(links) {
+        t.Fatalf("Got %d result(s), want %d", len(results), len(links))
+    }
+    for _, result := range results {
+        if !result.Checked {
+            t.Errorf("Link %s not checked", result.URL)
+        }
+    }
+    if results[0].Err != nil {
+        t.Errorf("Live link %s reported dead: %s", results[0].URL, results[0].Err.Error())
+    }
+    if results[1].Err == nil {
+        t.Errorf("Dead link %s reported alive", results[1].URL)
+    }
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+    "strings"
+    "github.com/EaterLabs/gophor/gopher"
+)
+
+/* Item:
+ * A single parsed menu line, the inverse of a line built by the
+ * server: type, display string, selector, host, port and whether
+ * the item is marked as a Gopher+ item (a trailing '+' field).
+ */
+type Item struct {
+    Type     gopher.ItemType
+    Display  string
+    Selector string
+    Host     string
+    Port     string
+    Plus     bool
+}
+
+/* Parse menu into items. Parsing is lenient, as menus found in the wild
+ * often are: missing fields are left empty and parsing stops at the
+ * terminating '.' line, if any.
+ */
+func ParseMenu(data []byte) []*Item {
+    items := make([]*Item, 0)
+    for _, line := range strings.Split(string(data), "\n") {
+        line = strings.TrimSuffix(line, "\r")
+        if line == gopher.End {
+            break
+        } else if line == "" {
+            continue
+        }
+
+        item := &Item{ gopher.ItemType(line[0]), "", "", "", "", false }
+        fields := strings.Split(line[1:], "\t")
+        item.Display = fields[0]
+        if len(fields) > 1 {
+            item.Selector = fields[1]
+        }
+        if len(fields) > 2 {
+            item.Host = fields[2]
+        }
+        if len(fields) > 3 {
+            item.Port = fields[3]
+        }
+        if len(fields) > 4 {
+            item.Plus = fields[4] == "+"
+        }
+        items = append(items, item)
+    }
+    return items
+}
+
+/* Check if item points somewhere, rather than just being text */
+func (i *Item) IsLink() bool {
+    return i.Type != gopher.TypeInfo && i.Type != gopher.TypeError
+}
+
+/* Get target of 'URL:' selectors (pointing outside of gopher), empty if not one */
+func (i *Item) ExternalURL() string {
+    if !strings.HasPrefix(i.Selector, "URL:") {
+        return ""
+    }
+    return strings.TrimPrefix(i.Selector, "URL:")
+}
+
+/* Get gopher URL item points to */
+func (i *Item) URL() *URL {
+    return &URL{ i.Host, i.Port, i.Type, i.Selector, "", "", false }
+}
+
+/* Format item back into a menu line */
+func (i *Item) String() string {
+    line := string(i.Type)+i.Display+"\t"+i.Selector+"\t"+i.Host+"\t"+i.Port
+    if i.Plus {
+        line += "\t+"
+    }
+    return line+gopher.DOSLineEnd
+}
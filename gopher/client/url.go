@@ -0,0 +1,115 @@
+package client
+
+import (
+    "fmt"
+    "net"
+    "strings"
+    "net/url"
+    "github.com/EaterLabs/gophor/gopher"
+)
+
+/* URL:
+ * A parsed gopher URL, as in RFC 4266:
+ * gopher://host:port/<type><selector>%09<query>%09<gopher+ string>
+ * with scheme 'gophers' requesting TLS. Selectors are kept raw
+ * (so '?' is part of the selector, not a URL query).
+ */
+type URL struct {
+    Host     string
+    Port     string
+    Type     gopher.ItemType
+    Selector string
+    Query    string
+    Plus     string
+    TLS      bool
+}
+
+func ParseURL(raw string) (*URL, error) {
+    u := &URL{ "", DefaultPort, gopher.TypeDirectory, "", "", "", false }
+
+    switch {
+        case strings.HasPrefix(raw, "gopher://"):
+            raw = strings.TrimPrefix(raw, "gopher://")
+        case strings.HasPrefix(raw, "gophers://"):
+            raw = strings.TrimPrefix(raw, "gophers://")
+            u.TLS = true
+        default:
+            return nil, fmt.Errorf("not a gopher URL: %s", raw)
+    }
+
+    /* Split host (and port) from the path */
+    hostPort, rest := raw, ""
+    if i := strings.Index(raw, "/"); i >= 0 {
+        hostPort, rest = raw[:i], raw[i+1:]
+    }
+    if host, port, err := net.SplitHostPort(hostPort); err == nil {
+        u.Host, u.Port = host, port
+    } else {
+        u.Host = hostPort
+    }
+    if u.Host == "" {
+        return nil, fmt.Errorf("no host in gopher URL: %s", raw)
+    }
+
+    /* Unescape the path, keeping it raw if badly escaped */
+    if unescaped, err := url.PathUnescape(rest); err == nil {
+        rest = unescaped
+    }
+    if rest == "" {
+        return u, nil
+    }
+
+    /* Type, then selector, query and gopher+ string separated by tabs */
+    u.Type = gopher.ItemType(rest[0])
+    fields := strings.SplitN(rest[1:], "\t", 3)
+    u.Selector = fields[0]
+    if len(fields) > 1 {
+        u.Query = fields[1]
+    }
+    if len(fields) > 2 {
+        u.Plus = fields[2]
+    }
+    return u, nil
+}
+
+func (u *URL) String() string {
+    scheme := "gopher"
+    if u.TLS {
+        scheme = "gophers"
+    }
+
+    str := scheme+"://"+net.JoinHostPort(u.Host, u.Port)+"/"+string(u.Type)+escapeSelector(u.Selector)
+    if u.Query != "" || u.Plus != "" {
+        str += "%09"+escapeSelector(u.Query)
+    }
+    if u.Plus != "" {
+        str += "%09"+escapeSelector(u.Plus)
+    }
+    return str
+}
+
+/* Line sent to the server requesting this URL */
+func (u *URL) RequestLine() string {
+    line := u.Selector
+    if u.Query != "" || u.Plus != "" {
+        line += "\t"+u.Query
+    }
+    if u.Plus != "" {
+        line += "\t"+u.Plus
+    }
+    return line+gopher.DOSLineEnd
+}
+
+/* Escape only what can't appear in a URL literally, so selectors stay readable */
+func escapeSelector(selector string) string {
+    escaped := ""
+    for i := 0; i < len(selector); i += 1 {
+        c := selector[i]
+        if c <= ' ' || c >= 0x7f || c == '%' || c == '#' {
+            escaped += fmt.Sprintf("%%%02X", c)
+        } else {
+            escaped += string(c)
+        }
+    }
+    return escaped
+}
@@ -0,0 +1,111 @@
+package gopher
+
+import (
+    "regexp"
+    "log"
+)
+
+/* ServerConfig:
+ * Holds onto a Server's configuration details
+ * and any data objects we want to keep in memory
+ * (e.g. loggers, restricted files regular expressions
+ * and file cache)
+ */
+type ServerConfig struct {
+    /* Base settings */
+    RootDir         string
+
+    /* Content settings */
+    FooterText      []byte
+    FooterSeparator bool
+    PageWidth       int
+    RestrictedFiles []*regexp.Regexp
+    TypeMap         *TypeMap
+    RewriteRules    *RewriteRules
+    ListSort        ListSort
+    ListDirsFirst   bool
+    ListStyle       ListStyle
+    RenderMarkdown  bool
+    ArchiveMaxSize  int64
+    DirArchiveExt   string
+    DirArchiveSize  int64
+    DirArchiveFiles int
+
+    /* Logging */
+    SystemLogger    *log.Logger
+    AccessLogger    *log.Logger
+    SystemLogLevel  LogLevel
+    AccessLogLevel  LogLevel
+
+    /* Filesystem access */
+    FileSystem      *FileSystem
+    UserDirs        *UserDirs
+    Search          *SearchIndex
+
+    /* Directory listing function, see listDir() */
+    listDir         func(request *FileSystemRequest, hidden map[string]bool) ([]byte, *GophorError)
+}
+
+func (config *ServerConfig) LogSystemDebug(fmt string, args ...interface{}) {
+    if config.SystemLogLevel > LogLevelDebug {
+        return
+    }
+    config.SystemLogger.Printf(":: D :: "+fmt, args...)
+}
+
+func (config *ServerConfig) LogSystem(fmt string, args ...interface{}) {
+    if config.SystemLogLevel > LogLevelInfo {
+        return
+    }
+    config.SystemLogger.Printf(":: I :: "+fmt, args...)
+}
+
+func (config *ServerConfig) LogSystemWarn(fmt string, args ...interface{}) {
+    if config.SystemLogLevel > LogLevelWarn {
+        return
+    }
+    config.SystemLogger.Printf(":: W :: "+fmt, args...)
+}
+
+func (config *ServerConfig) LogSystemError(fmt string, args ...interface{}) {
+    if config.SystemLogLevel > LogLevelError {
+        return
+    }
+    config.SystemLogger.Printf(":: E :: "+fmt, args...)
+}
+
+func (config *ServerConfig) LogSystemFatal(fmt string, args ...interface{}) {
+    /* Fatal is always logged, regardless of level */
+    config.SystemLogger.Fatalf(":: F :: "+fmt, args...)
+}
+
+func (config *ServerConfig) LogAccessDebug(sourceAddr, fmt string, args ...interface{}) {
+    if config.AccessLogLevel > LogLevelDebug {
+        return
+    }
+    config.AccessLogger.Printf(":: D :: ["+sourceAddr+"] "+fmt, args...)
+}
+
+func (config *ServerConfig) LogAccess(sourceAddr, fmt string, args ...interface{}) {
+    if config.AccessLogLevel > LogLevelInfo {
+        return
+    }
+    config.AccessLogger.Printf(":: I :: ["+sourceAddr+"] "+fmt, args...)
+}
+
+func (config *ServerConfig) LogAccessError(sourceAddr, fmt string, args ...interface{}) {
+    if config.AccessLogLevel > LogLevelError {
+        return
+    }
+    config.AccessLogger.Printf(":: E :: ["+sourceAddr+"] "+fmt, args...)
+}
+
+/* Trace a request through the system, tagged with its request ID. Only
+ * output when system log level is set to debug.
+ */
+func (config *ServerConfig) LogTrace(requestId uint64, fmt string, args ...interface{}) {
+    if config.SystemLogLevel > LogLevelDebug {
+        return
+    }
+    config.SystemLogger.Printf(":: D :: [#%d] "+fmt, append([]interface{}{ requestId }, args...)...)
+}
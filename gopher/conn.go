@@ -1,4 +1,4 @@
-package main
+package gopher
 
 import (
     "net"
@@ -19,19 +19,6 @@ type GophorListener struct {
     Host     *ConnHost
 }
 
-func BeginGophorListen(bindAddr, hostname, port string) (*GophorListener, error) {
-    gophorListener := new(GophorListener)
-    gophorListener.Host = &ConnHost{ hostname, port }
-
-    var err error
-    gophorListener.Listener, err = net.Listen("tcp", bindAddr+":"+port)
-    if err != nil {
-        return nil, err
-    } else {
-        return gophorListener, nil
-    }
-}
-
 func (l *GophorListener) Accept() (*GophorConn, error) {
     conn, err := l.Listener.Accept()
     if err != nil {
@@ -1,4 +1,4 @@
-package main
+package gopher
 
 const (
     /* Gophor */
@@ -9,10 +9,16 @@ const (
     MaxSocketReadChunks = 1
     FileReadBufSize     = 1024
 
+    /* Item type detection */
+    SniffBufSize      = 512  /* Bytes read from start of file to detect type */
+    ItemTypeCacheSize = 4096 /* Max sniffed item types held, least recently used pushed out */
+
     /* Parsing */
     DOSLineEnd = "\r\n"
     UnixLineEnd = "\n"
 
+    TabWidth = 8 /* Column stops used when expanding tabs in reflowed text */
+
     End = "."
     Tab = "\t"
     LastLine = End+DOSLineEnd
@@ -24,6 +30,10 @@ const (
     NullSelector = "-"
     NullHost = "null.host"
     NullPort = "0"
+    DefaultGopherPort = "70"
+
+    MinListNameWidth = 20 /* Below this, listing columns fall back to suffix */
+    ListDateFormat = "2006-01-02"
 
     SelectorErrorStr = "selector_length_error"
     GophermapRenderErrorStr = ""
@@ -36,6 +46,33 @@ const (
     GophermapFileStr = "gophermap"
     CapsTxtStr = "caps.txt"
     RobotsTxtStr = "robots.txt"
+    DirConfigFileStr = ".gophor"
+    DirConfigCacheSize = 1024 /* Max parsed directory configs held, least recently used pushed out */
+    MarkdownRawSuffix = "?raw" /* Appended to Markdown selectors to fetch unrendered source */
+
+    /* Phlog, queries appended to phlog directory selectors */
+    PhlogPageQuery = "?page="
+    PhlogYearQuery = "?year="
+    PhlogPageSize = 20 /* Default posts per index page */
+
+    /* Feeds, served from within directories with feeds enabled */
+    FeedFileStr = "feed.xml"
+    FeedEntryCount = 50 /* Max entries, newest-first */
+
+    /* Archives, browsed from their root with this appended to the selector */
+    ArchiveListQuery = "?list"
+
+    /* Cache keys for alternate views of a file are its path, this separator, then view name */
+    CacheViewSep = "\x00"
+
+    /* Search */
+    SearchMaxFileSize = 1048576 /* Larger files aren't indexed */
+    SearchMaxResults = 50
+    SearchSnippetWidth = 160 /* Bytes of text around first match */
+    SearchSnippetLines = 2
+
+    /* Gophermap parsing */
+    MaxGophermapIncludeDepth = 8
 
     /* Misc */
     BytesInMegaByte = 1048576.0
@@ -84,6 +121,7 @@ const (
     TypeEnd           = ItemType('.') /* [SERVER ONLY] Last line -- stop processing gophermap default */
     TypeSubGophermap  = ItemType('=') /* [SERVER ONLY] Include subgophermap / regular file here. */
     TypeEndBeginList  = ItemType('*') /* [SERVER ONLY] Last line + directory listing -- stop processing gophermap and end on a directory listing */
+    TypeUserList      = ItemType('~') /* [SERVER ONLY] List of all users with a user directory (~user) */
 
     /* Planned To Be Supported */
     TypeExec          = ItemType('$') /* [SERVER ONLY] Execute shell command and print stdout here */
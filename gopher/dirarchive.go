@@ -0,0 +1,254 @@
+package gopher
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "path"
+    "strings"
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+)
+
+/* Directory archive formats, by the extension appended to the directory selector */
+var dirArchiveExts = []string{ ".tar", ".tar.gz", ".zip" }
+
+/* DirArchiveEntry:
+ * A file or directory to be written into a directory archive,
+ * with its path on disk and name within the archive.
+ */
+type DirArchiveEntry struct {
+    Path string
+    Name string
+    Info os.FileInfo
+}
+
+/* Parse directory archive format flag, returning extension and ok */
+func parseDirArchiveFormat(format string) (string, bool) {
+    if format == "" {
+        return "", true
+    }
+
+    ext := "."+strings.TrimPrefix(strings.ToLower(format), ".")
+    for _, supported := range dirArchiveExts {
+        if ext == supported {
+            return ext, true
+        }
+    }
+    return "", false
+}
+
+/* Check if request path is for an archive of a directory, returning the directory path */
+func (config *ServerConfig) splitDirArchivePath(requestPath string) (string, bool) {
+    if config.DirArchiveExt == "" || !strings.HasSuffix(requestPath, config.DirArchiveExt) {
+        return "", false
+    }
+
+    dirPath := strings.TrimSuffix(requestPath, config.DirArchiveExt)
+    if dirPath == "" {
+        dirPath = "/"
+    }
+
+    stat, err := config.statContent(dirPath)
+    if err != nil || !stat.IsDir() {
+        return "", false
+    }
+    return dirPath, true
+}
+
+/* Get selector for archive of directory at selector */
+func (config *ServerConfig) dirArchiveSelector(selector string) string {
+    return strings.TrimSuffix(selector, "/")+config.DirArchiveExt
+}
+
+/* Stream archive of visible contents of directory at path to w, within the size + entry count limits */
+func (fs *FileSystem) HandleDirArchiveRequest(w io.Writer, request *FileSystemRequest, dirPath string) *GophorError {
+    /* Members go under a top-level directory named after the directory (or host, for root) */
+    prefix := path.Base(dirPath)
+    if dirPath == "/" {
+        prefix = request.Host.Name
+    }
+
+    /* Collect entries first so limits are checked before writing anything */
+    entries := make([]*DirArchiveEntry, 0)
+    var totalSize int64
+    gophorErr := fs.collectDirArchive(request, dirPath, prefix, &entries, &totalSize)
+    if gophorErr != nil {
+        return gophorErr
+    }
+    request.Trace("Streaming %s archive of %s: %d entries, %d bytes\n", fs.config.DirArchiveExt, dirPath, len(entries), totalSize)
+
+    if fs.config.DirArchiveExt == ".zip" {
+        return fs.config.writeDirZip(w, entries)
+    }
+    return fs.config.writeDirTar(w, entries, fs.config.DirArchiveExt == ".tar.gz")
+}
+
+/* Collect entries of directory at path and below, skipping those that wouldn't be listed */
+func (fs *FileSystem) collectDirArchive(request *FileSystemRequest, dirPath, name string, entries *[]*DirArchiveEntry, totalSize *int64) *GophorError {
+    dirConfig := fs.GetDirConfig(&FileSystemRequest{ dirPath, "", request.Host, request.Id, request.config }, dirPath)
+    if dirConfig.Deny {
+        return nil
+    }
+
+    files, err := fs.config.readContentDir(dirPath)
+    if err != nil {
+        return &GophorError{ DirListErr, err }
+    }
+    sortFiles(files, ListSortName, false)
+
+    /* Entries hidden by the directory's gophermap */
+    hidden := fs.config.readGophermapHidden(path.Join(dirPath, GophermapFileStr))
+
+    stat, err := fs.config.statContent(dirPath)
+    if err != nil {
+        return &GophorError{ FileStatErr, err }
+    }
+    *entries = append(*entries, &DirArchiveEntry{ dirPath, name, stat })
+    gophorErr := fs.checkDirArchiveLimits(*entries, *totalSize)
+    if gophorErr != nil {
+        return gophorErr
+    }
+
+    for _, file := range files {
+        if fs.config.isRestrictedFile(file.Name()) || hidden[file.Name()] || dirConfig.IsHidden(file.Name()) {
+            continue
+        }
+
+        itemPath := path.Join(dirPath, file.Name())
+        itemName := path.Join(name, file.Name())
+        switch {
+            case file.Mode() & os.ModeDir != 0:
+                gophorErr := fs.collectDirArchive(request, itemPath, itemName, entries, totalSize)
+                if gophorErr != nil {
+                    return gophorErr
+                }
+
+            case file.Mode() & os.ModeType == 0:
+                /* Regular file */
+                *totalSize += file.Size()
+                *entries = append(*entries, &DirArchiveEntry{ itemPath, itemName, file })
+                gophorErr := fs.checkDirArchiveLimits(*entries, *totalSize)
+                if gophorErr != nil {
+                    return gophorErr
+                }
+
+            default:
+                /* Ignore */
+        }
+    }
+
+    return nil
+}
+
+/* Check entries collected so far (directories included) are within the limits */
+func (fs *FileSystem) checkDirArchiveLimits(entries []*DirArchiveEntry, totalSize int64) *GophorError {
+    if len(entries) > fs.config.DirArchiveFiles {
+        return &GophorError{ ArchiveLimitErr, fmt.Errorf("more than %d entries", fs.config.DirArchiveFiles) }
+    } else if totalSize > fs.config.DirArchiveSize {
+        return &GophorError{ ArchiveLimitErr, fmt.Errorf("more than %d bytes", fs.config.DirArchiveSize) }
+    }
+    return nil
+}
+
+/* Write entries as (optionally gzipped) tar archive */
+func (config *ServerConfig) writeDirTar(writer io.Writer, entries []*DirArchiveEntry, compress bool) *GophorError {
+    var gzipWriter *gzip.Writer
+    if compress {
+        gzipWriter = gzip.NewWriter(writer)
+        writer = gzipWriter
+    }
+
+    tarWriter := tar.NewWriter(writer)
+    for _, entry := range entries {
+        header, err := tar.FileInfoHeader(entry.Info, "")
+        if err != nil {
+            return &GophorError{ FileReadErr, err }
+        }
+        header.Name = entry.Name
+        if entry.Info.IsDir() {
+            header.Name += "/"
+        }
+
+        /* Don't give away server users */
+        header.Uid, header.Gid     = 0, 0
+        header.Uname, header.Gname = "", ""
+
+        err = tarWriter.WriteHeader(header)
+        if err != nil {
+            return &GophorError{ FileReadErr, err }
+        }
+
+        if !entry.Info.IsDir() {
+            gophorErr := config.copyFileTo(tarWriter, entry.Path)
+            if gophorErr != nil {
+                return gophorErr
+            }
+        }
+    }
+
+    err := tarWriter.Close()
+    if err != nil {
+        return &GophorError{ FileReadErr, err }
+    }
+
+    /* Flushes the rest of the compressed stream */
+    if gzipWriter != nil {
+        err = gzipWriter.Close()
+        if err != nil {
+            return &GophorError{ FileReadErr, err }
+        }
+    }
+    return nil
+}
+
+/* Write entries as zip archive */
+func (config *ServerConfig) writeDirZip(writer io.Writer, entries []*DirArchiveEntry) *GophorError {
+    zipWriter := zip.NewWriter(writer)
+    for _, entry := range entries {
+        header, err := zip.FileInfoHeader(entry.Info)
+        if err != nil {
+            return &GophorError{ FileReadErr, err }
+        }
+        header.Name = entry.Name
+        if entry.Info.IsDir() {
+            header.Name += "/"
+        } else {
+            header.Method = zip.Deflate
+        }
+
+        fileWriter, err := zipWriter.CreateHeader(header)
+        if err != nil {
+            return &GophorError{ FileReadErr, err }
+        }
+
+        if !entry.Info.IsDir() {
+            gophorErr := config.copyFileTo(fileWriter, entry.Path)
+            if gophorErr != nil {
+                return gophorErr
+            }
+        }
+    }
+
+    err := zipWriter.Close()
+    if err != nil {
+        return &GophorError{ FileReadErr, err }
+    }
+    return nil
+}
+
+/* Copy contents of file at path to writer */
+func (config *ServerConfig) copyFileTo(writer io.Writer, filePath string) *GophorError {
+    fd, err := config.openContent(filePath)
+    if err != nil {
+        return &GophorError{ FileOpenErr, err }
+    }
+    defer fd.Close()
+
+    _, err = io.Copy(writer, fd)
+    if err != nil {
+        return &GophorError{ FileReadErr, err }
+    }
+    return nil
+}
@@ -0,0 +1,349 @@
+package gopher
+
+import (
+    "os"
+    "fmt"
+    "path"
+    "sync"
+    "bufio"
+    "strings"
+    "strconv"
+    "container/list"
+)
+
+/* DirConfig:
+ * Per-directory settings parsed from an optional config file
+ * (DirConfigFileStr) within a directory. Settings are inherited
+ * by child directories, except for the title, phlog and feed
+ * settings which only apply to the directory they were set in.
+ */
+type DirConfig struct {
+    Title         string
+    Footer        []byte
+    Hidden        []string
+    Types         []*TypeMapGlob
+    Sort          ListSort
+    DirsFirst     bool
+    Style         ListStyle
+    Deny          bool
+    Phlog         bool
+    PhlogPageSize int
+    Feed          bool
+}
+
+/* Check if named entry is hidden by this config */
+func (dc *DirConfig) IsHidden(name string) bool {
+    /* Never show the config file itself */
+    if name == DirConfigFileStr {
+        return true
+    }
+
+    for _, pattern := range dc.Hidden {
+        if ok, _ := path.Match(pattern, name); ok {
+            return true
+        }
+    }
+    return false
+}
+
+/* Get forced item type for named entry, returning false if none */
+func (dc *DirConfig) ItemType(name string) (ItemType, bool) {
+    for _, glob := range dc.Types {
+        if ok, _ := path.Match(glob.Pattern, name); ok {
+            return glob.Type, true
+        }
+    }
+    return TypeDefault, false
+}
+
+/* Returns new DirConfig of this (parent) config with child settings applied */
+func (dc *DirConfig) Inherit(child *DirConfig) *DirConfig {
+    merged := &DirConfig{
+        child.Title,
+        dc.Footer,
+        append(append([]string{}, dc.Hidden...), child.Hidden...),
+        /* Child type patterns checked first so they take priority */
+        append(append([]*TypeMapGlob{}, child.Types...), dc.Types...),
+        dc.Sort,
+        dc.DirsFirst,
+        dc.Style,
+        dc.Deny || child.Deny,
+        child.Phlog,
+        child.PhlogPageSize,
+        child.Feed,
+    }
+
+    if child.Footer != nil {
+        merged.Footer = child.Footer
+    }
+    if child.Sort != ListSortUnset {
+        merged.Sort      = child.Sort
+        merged.DirsFirst = child.DirsFirst
+    }
+    if child.Style != ListStyleUnset {
+        merged.Style = child.Style
+    }
+
+    return merged
+}
+
+/* DirConfigCache:
+ * Holds onto parsed per-directory config files. Kept apart from
+ * the file cache as configs are read while building listings, i.e.
+ * within a file cache callback where the cache lock is already held.
+ * Entries are keyed by path, modification time and size, so a changed
+ * config misses and its stale entry is pushed out as the least recently
+ * used once the cache is full.
+ */
+type DirConfigCache struct {
+    Map   map[string]*DirConfigEntry
+    List  *list.List
+    Mutex sync.Mutex
+    Size  int
+
+    config *ServerConfig
+}
+
+type DirConfigEntry struct {
+    Element   *list.Element
+    DirConfig *DirConfig
+}
+
+func NewDirConfigCache(config *ServerConfig, size int) *DirConfigCache {
+    return &DirConfigCache{
+        make(map[string]*DirConfigEntry),
+        list.New(),
+        sync.Mutex{},
+        size,
+        config,
+    }
+}
+
+/* Get parsed config file at path, reading it if not cached for this version of the file */
+func (c *DirConfigCache) Get(path string, stat os.FileInfo) (*DirConfig, *GophorError) {
+    key := statCacheKey(path, stat)
+
+    c.Mutex.Lock()
+    entry, ok := c.Map[key]
+    if ok {
+        c.List.MoveToFront(entry.Element)
+    }
+    c.Mutex.Unlock()
+
+    if ok {
+        return entry.DirConfig, nil
+    }
+
+    /* Don't cache failures, file may be fixed without changing size or time */
+    dirConfig, gophorErr := c.config.readDirConfig(path)
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+
+    c.Mutex.Lock()
+    if _, ok := c.Map[key]; !ok {
+        c.Map[key] = &DirConfigEntry{ c.List.PushFront(key), dirConfig }
+
+        /* Push out least recently used if size limit reached */
+        if c.List.Len() > c.Size {
+            element := c.List.Back()
+            delete(c.Map, element.Value.(string))
+            c.List.Remove(element)
+        }
+    }
+    c.Mutex.Unlock()
+
+    return dirConfig, nil
+}
+
+/* Get the effective DirConfig for directory at dirPath, built by walking
+ * down from the root and applying each directory's config file in turn.
+ */
+func (fs *FileSystem) GetDirConfig(request *FileSystemRequest, dirPath string) *DirConfig {
+    /* Start from server defaults */
+    dirConfig := &DirConfig{}
+    dirConfig.Sort      = fs.config.ListSort
+    dirConfig.DirsFirst = fs.config.ListDirsFirst
+    dirConfig.Style     = fs.config.ListStyle
+
+    /* Build list of directories from root down */
+    dirs := []string{ "/" }
+    current := "/"
+    for _, part := range strings.Split(strings.Trim(dirPath, "/"), "/") {
+        if part == "" {
+            continue
+        }
+        current = path.Join(current, part)
+        dirs = append(dirs, current)
+    }
+
+    for _, dir := range dirs {
+        configPath := path.Join(dir, DirConfigFileStr)
+        stat, err := fs.config.statContent(configPath)
+        if err != nil {
+            /* No config here, child directories don't inherit titles */
+            dirConfig = dirConfig.Inherit(&DirConfig{})
+            continue
+        }
+
+        child, gophorErr := fs.DirConfigs.Get(configPath, stat)
+        if gophorErr != nil {
+            /* Fail closed, a broken config may have been meant to deny access */
+            fs.config.LogSystemError("Failed to read directory config %s, denying access to %s: %s\n", configPath, dirPath, gophorErr.Error())
+            dirConfig.Deny = true
+            return dirConfig
+        }
+
+        request.Trace("Applying directory config: %s\n", configPath)
+        dirConfig = dirConfig.Inherit(child)
+    }
+
+    return dirConfig
+}
+
+/* Parse directory config file at path. Each line takes the form
+ * 'key: value', '#' starts a comment.
+ */
+func (config *ServerConfig) readDirConfig(configPath string) (*DirConfig, *GophorError) {
+    dirConfig := &DirConfig{}
+
+    lineNo := 0
+    var parseErr *GophorError
+    gophorErr := config.bufferedScan(configPath,
+        func(scanner *bufio.Scanner) bool {
+            lineNo += 1
+            line := strings.TrimSpace(scanner.Text())
+
+            /* Skip empty lines and comments */
+            if line == "" || strings.HasPrefix(line, "#") {
+                return true
+            }
+
+            /* Split into key and (optional) value */
+            key, value := line, ""
+            if i := strings.Index(line, ":"); i >= 0 {
+                key   = strings.TrimSpace(line[:i])
+                value = strings.TrimSpace(line[i+1:])
+            }
+
+            switch strings.ToLower(key) {
+                case "title":
+                    dirConfig.Title = value
+
+                case "footer":
+                    /* Each footer line appends to footer text, formatted later */
+                    if len(dirConfig.Footer) == 0 {
+                        dirConfig.Footer = []byte(value)
+                    } else {
+                        dirConfig.Footer = append(dirConfig.Footer, []byte("\n"+value)...)
+                    }
+
+                case "no-footer":
+                    /* Empty, non-nil footer overrides any inherited */
+                    dirConfig.Footer = []byte{}
+
+                case "hide":
+                    for _, pattern := range strings.Fields(value) {
+                        dirConfig.Hidden = append(dirConfig.Hidden, pattern)
+                    }
+
+                case "type":
+                    fields := strings.Fields(value)
+                    if len(fields) < 2 || len(fields[0]) != 1 {
+                        parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid type on line %d", configPath, lineNo) }
+                        return false
+                    }
+                    for _, pattern := range fields[1:] {
+                        dirConfig.Types = append(dirConfig.Types, &TypeMapGlob{ pattern, ItemType(fields[0][0]) })
+                    }
+
+                case "sort":
+                    listSort, dirsFirst, ok := parseListSort(value)
+                    if !ok {
+                        parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid sort on line %d", configPath, lineNo) }
+                        return false
+                    }
+                    dirConfig.Sort      = listSort
+                    dirConfig.DirsFirst = dirsFirst
+
+                case "style":
+                    listStyle, ok := parseListStyle(value)
+                    if !ok {
+                        parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid style on line %d", configPath, lineNo) }
+                        return false
+                    }
+                    dirConfig.Style = listStyle
+
+                case "deny":
+                    /* A bare 'deny' line means true */
+                    deny := true
+                    if value != "" {
+                        var err error
+                        deny, err = strconv.ParseBool(value)
+                        if err != nil {
+                            parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid deny on line %d", configPath, lineNo) }
+                            return false
+                        }
+                    }
+                    dirConfig.Deny = deny
+
+                case "phlog":
+                    /* A bare 'phlog' line means true */
+                    phlog := true
+                    if value != "" {
+                        var err error
+                        phlog, err = strconv.ParseBool(value)
+                        if err != nil {
+                            parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid phlog on line %d", configPath, lineNo) }
+                            return false
+                        }
+                    }
+                    dirConfig.Phlog = phlog
+
+                case "feed":
+                    /* A bare 'feed' line means true */
+                    feed := true
+                    if value != "" {
+                        var err error
+                        feed, err = strconv.ParseBool(value)
+                        if err != nil {
+                            parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid feed on line %d", configPath, lineNo) }
+                            return false
+                        }
+                    }
+                    dirConfig.Feed = feed
+
+                case "phlog-page-size":
+                    pageSize, err := strconv.Atoi(value)
+                    if err != nil || pageSize < 1 {
+                        parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid phlog page size on line %d", configPath, lineNo) }
+                        return false
+                    }
+                    dirConfig.PhlogPageSize = pageSize
+
+                default:
+                    parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: unknown key '%s' on line %d", configPath, key, lineNo) }
+                    return false
+            }
+
+            return true
+        },
+    )
+
+    if gophorErr != nil {
+        return nil, gophorErr
+    } else if parseErr != nil {
+        return nil, parseErr
+    }
+
+    /* Format footer now so it is ready to send */
+    if dirConfig.Footer != nil {
+        if len(dirConfig.Footer) == 0 {
+            dirConfig.Footer = []byte(LastLine)
+        } else {
+            dirConfig.Footer = config.formatGophermapFooter(string(dirConfig.Footer), config.FooterSeparator)
+        }
+    }
+
+    return dirConfig, nil
+}
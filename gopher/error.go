@@ -1,4 +1,4 @@
-package main
+package gopher
 
 import (
     "fmt"
@@ -16,6 +16,8 @@ const (
     FileReadErr         ErrorCode = iota
     FileTypeErr         ErrorCode = iota
     DirListErr          ErrorCode = iota
+    GoneErr             ErrorCode = iota
+    ArchiveLimitErr     ErrorCode = iota
     
     /* Sockets */
     SocketWriteErr      ErrorCode = iota
@@ -27,6 +29,9 @@ const (
     EntityPortParseErr  ErrorCode = iota
     InvalidGophermapErr ErrorCode = iota
 
+    /* Config */
+    ConfigParseErr      ErrorCode = iota
+
     /* Error Response Codes */
     ErrorResponse200 ErrorResponseCode = iota
     ErrorResponse400 ErrorResponseCode = iota
@@ -65,6 +70,10 @@ func (e *GophorError) Error() string {
             str = "invalid file type"
         case DirListErr:
             str = "directory read fail"
+        case GoneErr:
+            str = "resource removed"
+        case ArchiveLimitErr:
+            str = "archive limit exceeded"
 
         case SocketWriteErr:
             str = "socket write fail"
@@ -80,6 +89,9 @@ func (e *GophorError) Error() string {
         case InvalidGophermapErr:
             str = "invalid gophermap"
 
+        case ConfigParseErr:
+            str = "config parse fail"
+
         default:
             str = "Unknown"
     }
@@ -109,6 +121,10 @@ func gophorErrorToResponseCode(code ErrorCode) ErrorResponseCode {
             return ErrorResponse404
         case DirListErr:
             return ErrorResponse404
+        case GoneErr:
+            return ErrorResponse410
+        case ArchiveLimitErr:
+            return ErrorResponse403
 
         /* These are errors _while_ sending, no point trying to send error  */
         case SocketWriteErr:
@@ -125,6 +141,9 @@ func gophorErrorToResponseCode(code ErrorCode) ErrorResponseCode {
         case InvalidGophermapErr:
             return ErrorResponse500
 
+        case ConfigParseErr:
+            return ErrorResponse500
+
         default:
             return ErrorResponse503
     }
@@ -169,7 +188,6 @@ func (e ErrorResponseCode) String() string {
             return "503 Service Unavailable"
         default:
             /* Should not have reached here */
-            Config.LogSystemFatal("Unhandled ErrorResponseCode type\n")
-            return ""
+            panic("Unhandled ErrorResponseCode type")
     }
 }
@@ -0,0 +1,73 @@
+package gopher
+
+import (
+    "path"
+    "time"
+    "bytes"
+    "encoding/xml"
+)
+
+/* Render Atom feed of the (non-hidden) posts in phlog, newest-first */
+func (pc *PhlogContents) RenderFeed(request *FileSystemRequest, dirConfig *DirConfig) []byte {
+    selector := pc.config.UserDirs.Selector(request.Path)
+    dirUrl := buildGopherUrl(TypeDirectory, selector, request.Host.Name, request.Host.Port)
+    posts := pc.visiblePosts(dirConfig)
+    if len(posts) > FeedEntryCount {
+        posts = posts[:FeedEntryCount]
+    }
+
+    title := request.Host.Name+selector
+    if dirConfig.Title != "" {
+        title = dirConfig.Title
+    }
+
+    /* Feed updated time is that of the newest post */
+    updated := time.Unix(0, 0)
+    if len(posts) > 0 {
+        updated = posts[0].Date
+    }
+
+    buf := &bytes.Buffer{}
+    buf.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+    buf.WriteString("<feed xmlns=\"http://www.w3.org/2005/Atom\">\n")
+    buf.WriteString("  <title>"+xmlEscape(title)+"</title>\n")
+    buf.WriteString("  <id>"+xmlEscape(dirUrl)+"</id>\n")
+    buf.WriteString("  <link href=\""+xmlEscape(dirUrl)+"\"/>\n")
+    buf.WriteString("  <link rel=\"self\" href=\""+xmlEscape(buildGopherUrl(TypeXml, path.Join(selector, FeedFileStr), request.Host.Name, request.Host.Port))+"\"/>\n")
+    buf.WriteString("  <updated>"+updated.Format(time.RFC3339)+"</updated>\n")
+    buf.WriteString("  <author><name>"+xmlEscape(request.Host.Name)+"</name></author>\n")
+
+    for _, post := range posts {
+        postUrl := buildGopherUrl(phlogPostType(post, dirConfig), pc.config.UserDirs.Selector(post.Path), request.Host.Name, request.Host.Port)
+        buf.WriteString("  <entry>\n")
+        buf.WriteString("    <title>"+xmlEscape(post.Title)+"</title>\n")
+        buf.WriteString("    <id>"+xmlEscape(postUrl)+"</id>\n")
+        buf.WriteString("    <link href=\""+xmlEscape(postUrl)+"\"/>\n")
+        buf.WriteString("    <updated>"+post.Date.Format(time.RFC3339)+"</updated>\n")
+        buf.WriteString("  </entry>\n")
+    }
+
+    buf.WriteString("</feed>\n")
+    return buf.Bytes()
+}
+
+/* Get Atom feed for directory at request path */
+func (fs *FileSystem) FetchFeed(request *FileSystemRequest, dirConfig *DirConfig) ([]byte, *GophorError) {
+    var output []byte
+    gophorErr := fs.fetchPhlog(request, func(phlog *PhlogContents) {
+        output = phlog.RenderFeed(request, dirConfig)
+    })
+    return output, gophorErr
+}
+
+/* Build feed link line for directory at selector */
+func (config *ServerConfig) buildFeedLine(selector string, connHost *ConnHost) []byte {
+    return config.buildLine(TypeXml, "Atom feed", path.Join(selector, FeedFileStr), connHost.Name, connHost.Port)
+}
+
+/* Escape string for use in XML text or attributes */
+func xmlEscape(str string) string {
+    buf := &bytes.Buffer{}
+    xml.EscapeText(buf, []byte(str))
+    return buf.String()
+}
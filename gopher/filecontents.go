@@ -0,0 +1,490 @@
+package gopher
+
+import (
+    "fmt"
+    "path"
+    "bytes"
+    "bufio"
+    "strings"
+)
+
+/* GeneratedFileContents:
+ * The simplest implementation of FileContents that
+ * stores some bytes and does nothing else.
+ */
+type GeneratedFileContents struct {
+    contents []byte
+}
+
+func (fc *GeneratedFileContents) Render(request *FileSystemRequest) []byte {
+    return fc.contents
+}
+
+func (fc *GeneratedFileContents) Load() *GophorError {
+    /* do nothing */
+    return nil
+}
+
+func (fc *GeneratedFileContents) Clear() {
+    /* do nothing */
+}
+
+/* RegularFileContents:
+ * Very simple implementation of FileContents that just
+ * buffered reads from the stored file path, stores the
+ * read bytes in a slice and returns when requested.
+ */
+type RegularFileContents struct {
+    path     string
+    contents []byte
+    config   *ServerConfig
+}
+
+func (fc *RegularFileContents) Render(request *FileSystemRequest) []byte {
+    /* Here we can ignore the extra data in request.
+     * We are but a simple cache'd file
+     */
+    return fc.contents
+}
+
+func (fc *RegularFileContents) Load() *GophorError {
+    /* Load the file into memory */
+    var gophorErr *GophorError
+    fc.contents, gophorErr = fc.config.bufferedRead(fc.path)
+    return gophorErr
+}
+
+func (fc *RegularFileContents) Clear() {
+    fc.contents = nil
+}
+
+/* GophermapContents:
+ * Implementation of FileContents that reads and
+ * parses a gophermap file into a slice of gophermap
+ * sections, then renders and returns these sections
+ * when requested.
+ */
+type GophermapContents struct {
+    path     string
+    sections []GophermapSection
+    deps     map[string]bool
+    config   *ServerConfig
+}
+
+func (gc *GophermapContents) Render(request *FileSystemRequest) []byte {
+    returnContents := make([]byte, 0)
+
+    /* We don't just want to read the contents, each section
+     * in the sections slice needs a call to render() to
+     * perform their own required actions in producing a
+     * sendable byte slice.
+     */
+    for i, line := range gc.sections {
+        content, gophorErr := line.Render(request)
+        if gophorErr != nil {
+            request.Trace("Gophermap section %d failed to render: %s\n", i, gophorErr.Error())
+            content = gc.config.buildInfoLine(GophermapRenderErrorStr)
+        }
+        request.Trace("Rendered gophermap section %d (%T): %d bytes\n", i, line, len(content))
+        returnContents = append(returnContents, content...)
+    }
+
+    /* The footer added later contains last line, don't need to worry */
+
+    return returnContents
+}
+
+func (gc *GophermapContents) Load() *GophorError {
+    /* Load the gophermap into memory as gophermap sections */
+    var gophorErr *GophorError
+    gc.sections, gc.deps, gophorErr = gc.config.readGophermap(gc.path)
+    return gophorErr
+}
+
+func (gc *GophermapContents) Clear() {
+    gc.sections = nil
+    gc.deps     = nil
+}
+
+func (gc *GophermapContents) Dependencies() map[string]bool {
+    return gc.deps
+}
+
+/* DependentContents:
+ * Implemented by FileContents built from more than just the
+ * file at their own path (e.g. gophermaps including other
+ * files). Returns a map of each dependency path to whether it
+ * existed at load time, so the freshness monitor can invalidate
+ * when any of them change, appear or disappear.
+ */
+type DependentContents interface {
+    Dependencies() map[string]bool
+}
+
+/* GophermapSection:
+ * Provides an interface for different stored sections
+ * of a gophermap file, whether it's static text that we
+ * may want stored as-is, or the data required for a dir
+ * listing or command executed that we may want updated
+ * upon each file cache request.
+ */
+type GophermapSection interface {
+    Render(*FileSystemRequest) ([]byte, *GophorError)
+}
+
+/* GophermapText:
+ * Simple implementation of GophermapSection that holds
+ * onto a static section of text as a slice of bytes.
+ */
+type GophermapText struct {
+    Contents []byte
+}
+
+func NewGophermapText(contents []byte) *GophermapText {
+    return &GophermapText{ contents }
+}
+
+func (s *GophermapText) Render(request *FileSystemRequest) ([]byte, *GophorError) {
+    return replaceStrings(string(s.Contents), request.Host), nil
+}
+
+/* GophermapDirListing:
+ * An implementation of GophermapSection that holds onto a
+ * path and a requested list of hidden files, then enumerates
+ * the supplied paths (ignoring hidden files) when the content
+ * Render() call is received.
+ */
+type GophermapDirListing struct {
+    Path   string
+    Hidden map[string]bool
+
+    config *ServerConfig
+}
+
+func NewGophermapDirListing(config *ServerConfig, path string) *GophermapDirListing {
+    return &GophermapDirListing{ path, nil, config }
+}
+
+func (s *GophermapDirListing) Render(request *FileSystemRequest) ([]byte, *GophorError) {
+    /* We could just pass the request directly, but in case the request
+     * path happens to differ for whatever reason we create a new one
+     */
+    return s.config.listDir(&FileSystemRequest{ s.Path, "", request.Host, request.Id, request.config }, s.Hidden)
+}
+
+func (config *ServerConfig) readGophermap(gophermapPath string) ([]GophermapSection, map[string]bool, *GophorError) {
+    deps := make(map[string]bool)
+    reader := config.newGophermapReader(gophermapPath, deps)
+    gophorErr := config.walkGophermap(gophermapPath, []string{ gophermapPath }, reader)
+    if gophorErr != nil {
+        return nil, nil, gophorErr
+    }
+    return reader.Sections(), deps, nil
+}
+
+/* GophermapVisitor:
+ * Receives the lines of a gophermap as read by walkGophermap(), so
+ * that serving and checking gophermaps share the same parsing. '='
+ * includes are resolved, and include cycles or too deep nesting
+ * caught, before the visitor is told of them. An included gophermap
+ * is only read if the visitor calls walk, with a visitor for it.
+ */
+type GophermapVisitor interface {
+    /* Any line other than an include, return false to stop reading */
+    Line(lineNo int, lineType ItemType, line string) bool
+    IncludeFile(lineNo int, includePath string)
+    IncludeGophermap(lineNo int, includePath string, walk func(GophermapVisitor) *GophorError)
+    IncludeError(lineNo int, reason string)
+}
+
+/* Read gophermap at path, passing each line to visitor, where includeChain
+ * holds the paths of all gophermaps leading to (and including) this one.
+ * Used to detect include cycles and limit include depth.
+ */
+func (config *ServerConfig) walkGophermap(gophermapPath string, includeChain []string, visitor GophermapVisitor) *GophorError {
+    /* Keep track of line number for error reporting */
+    lineNo := 0
+
+    /* Perform buffered scan with our supplied splitter and iterators */
+    return config.bufferedScan(gophermapPath,
+        func(scanner *bufio.Scanner) bool {
+            line := scanner.Text()
+            lineNo += 1
+
+            /* Includes are handled here, everything else by the visitor */
+            lineType := parseLineType(line)
+            if lineType != TypeSubGophermap {
+                return visitor.Line(lineNo, lineType, line)
+            }
+
+            /* Resolve include path relative to the current gophermap */
+            includePath := resolveIncludePath(gophermapPath, line[1:])
+
+            /* Check if we've been supplied subgophermap or regular file */
+            if !strings.HasSuffix(includePath, GophermapFileStr) {
+                visitor.IncludeFile(lineNo, includePath)
+                return true
+            }
+
+            /* Copy the chain so sibling includes don't share it */
+            subChain := append(append([]string{}, includeChain...), includePath)
+
+            /* Ensure this include doesn't lead back to a gophermap in the chain. Recursion bad! */
+            for _, chainPath := range includeChain {
+                if chainPath == includePath {
+                    visitor.IncludeError(lineNo, "include cycle: "+strings.Join(subChain, " -> "))
+                    return true
+                }
+            }
+
+            /* Ensure we're not nested too deep */
+            if len(includeChain) >= MaxGophermapIncludeDepth {
+                visitor.IncludeError(lineNo, fmt.Sprintf("max include depth %d reached including %s", MaxGophermapIncludeDepth, includePath))
+                return true
+            }
+
+            /* Treat as any other gopher map! */
+            visitor.IncludeGophermap(lineNo, includePath, func(subVisitor GophermapVisitor) *GophorError {
+                return config.walkGophermap(includePath, subChain, subVisitor)
+            })
+            return true
+        },
+    )
+}
+
+/* gophermapReader:
+ * GophermapVisitor building the sections of a gophermap to be
+ * served, recording every included file in deps.
+ */
+type gophermapReader struct {
+    path         string
+    deps         map[string]bool
+    sections     []GophermapSection
+    hidden       map[string]bool
+    titleAlready bool
+    dirListing   *GophermapDirListing
+
+    config       *ServerConfig
+}
+
+func (config *ServerConfig) newGophermapReader(gophermapPath string, deps map[string]bool) *gophermapReader {
+    return &gophermapReader{ gophermapPath, deps, make([]GophermapSection, 0), make(map[string]bool), false, nil, config }
+}
+
+func (r *gophermapReader) Line(lineNo int, lineType ItemType, line string) bool {
+    switch lineType {
+        case TypeInfoNotStated:
+            /* Append TypeInfo to the beginning of line */
+            r.sections = append(r.sections, NewGophermapText(r.config.buildInfoLine(line)))
+
+        case TypeTitle:
+            /* Reformat title line to send as info line with appropriate selector */
+            if !r.titleAlready {
+                r.sections = append(r.sections, NewGophermapText(r.config.buildLine(TypeInfo, line[1:], "TITLE", NullHost, NullPort)))
+                r.titleAlready = true
+            }
+
+        case TypeComment:
+            /* We ignore this line */
+            break
+
+        case TypeHiddenFile:
+            /* Add to hidden files map */
+            r.hidden[line[1:]] = true
+
+        case TypeUserList:
+            /* List all users with a user directory, enumerated at render */
+            r.sections = append(r.sections, &GophermapUserListing{ r.config })
+
+        case TypeExec:
+            /* Try executing supplied line */
+            r.sections = append(r.sections, NewGophermapText(r.config.buildInfoLine("Error: inline shell commands not yet supported")))
+
+        case TypeEnd:
+            /* Lastline, break out at end of loop. Interface method Contents()
+             * will append a last line at the end so we don't have to worry about
+             * that here, only stopping the loop.
+             */
+            return false
+
+        case TypeEndBeginList:
+            /* Create GophermapDirListing object then break out at end of loop */
+            r.dirListing = NewGophermapDirListing(r.config, strings.TrimSuffix(r.path, GophermapFileStr))
+            return false
+
+        default:
+            /* Complete any missing fields then append to sections slice as gophermap text */
+            r.sections = append(r.sections, NewGophermapText([]byte(r.config.completeGophermapLine(line, r.path)+DOSLineEnd)))
+    }
+
+    return true
+}
+
+func (r *gophermapReader) IncludeFile(lineNo int, includePath string) {
+    /* Treat as regular file, but we need to replace Unix line endings
+     * with gophermap line endings
+     */
+    r.config.addDependency(r.deps, includePath)
+    fileContents, gophorErr := r.config.readIntoGophermap(includePath)
+    if gophorErr != nil {
+        /* Failed to read file, insert error line */
+        r.IncludeError(lineNo, "error reading file "+includePath+": "+gophorErr.Error())
+    } else {
+        r.sections = append(r.sections, NewGophermapText(fileContents))
+    }
+}
+
+func (r *gophermapReader) IncludeGophermap(lineNo int, includePath string, walk func(GophermapVisitor) *GophorError) {
+    r.config.addDependency(r.deps, includePath)
+    submap := r.config.newGophermapReader(includePath, r.deps)
+    gophorErr := walk(submap)
+    if gophorErr != nil {
+        /* Failed to read subgophermap, insert error line */
+        r.IncludeError(lineNo, "error reading subgophermap "+includePath+": "+gophorErr.Error())
+    } else {
+        r.sections = append(r.sections, submap.Sections()...)
+    }
+}
+
+/* Log include failure with file and line number, inserting an error section in its place */
+func (r *gophermapReader) IncludeError(lineNo int, reason string) {
+    r.config.LogSystemError("%s:%d: %s\n", r.path, lineNo, reason)
+    r.sections = append(r.sections, NewGophermapText(r.config.buildInfoLine("Error: "+reason)))
+}
+
+/* Get sections read. If dir listing requested, append the hidden files
+ * map then add to sections slice. We can do this here as the
+ * TypeEndBeginList item type ALWAYS comes last, at least in the
+ * gophermap read by this reader.
+ */
+func (r *gophermapReader) Sections() []GophermapSection {
+    if r.dirListing != nil {
+        r.dirListing.Hidden = r.hidden
+        return append(r.sections, r.dirListing)
+    }
+    return r.sections
+}
+
+/* Fill in missing fields of a gophermap menu line, as Gophernicus and
+ * Bucktooth do. Missing host and port become the replacement strings,
+ * swapped for the connection's host details at render time. An empty
+ * selector takes the item name, and relative local selectors are
+ * resolved against the gophermap's directory. External 'URL:' selectors
+ * are left alone.
+ */
+func (config *ServerConfig) completeGophermapLine(line, gophermapPath string) string {
+    fields := strings.Split(line, Tab)
+    itemType := ItemType(line[0])
+
+    /* Info and error lines aren't selectable, leave as-is */
+    if itemType == TypeInfo || itemType == TypeError {
+        return line
+    }
+
+    /* Make sure we have name, selector, host + port fields */
+    for len(fields) < 4 {
+        fields = append(fields, "")
+    }
+    name, selector, host, port := fields[0][1:], fields[1], fields[2], fields[3]
+
+    /* Local if no host supplied (or is placeholder for ours) */
+    isLocal := (host == "" || host == ReplaceStrHostname)
+
+    switch {
+        case itemType == TypeTelnet || itemType == TypeTn3270:
+            /* Selector is a login name for these, never resolve */
+            break
+
+        case strings.HasPrefix(selector, "URL:"):
+            /* External URL, leave alone */
+            break
+
+        case selector == "" && isLocal:
+            /* Gophernicus-style, use name as selector */
+            selector = config.resolveSelector(gophermapPath, name)
+
+        case isLocal && !strings.HasPrefix(selector, "/"):
+            selector = config.resolveSelector(gophermapPath, selector)
+    }
+
+    /* Fill in host and port */
+    if host == "" {
+        host = ReplaceStrHostname
+    }
+    if port == "" {
+        if host == ReplaceStrHostname {
+            port = ReplaceStrPort
+        } else {
+            port = DefaultGopherPort
+        }
+    }
+
+    fields[0], fields[1], fields[2], fields[3] = string(itemType)+name, selector, host, port
+    return strings.Join(fields, Tab)
+}
+
+/* Resolve a selector relative to a gophermap's directory */
+func (config *ServerConfig) resolveSelector(gophermapPath, selector string) string {
+    return path.Join(config.UserDirs.Selector(path.Dir(gophermapPath)), selector)
+}
+
+/* Resolve an include path from a gophermap, relative paths are
+ * resolved against the including gophermap's directory
+ */
+func resolveIncludePath(gophermapPath, includePath string) string {
+    if strings.HasPrefix(includePath, "/") {
+        return path.Clean(includePath)
+    }
+    return path.Join(path.Dir(gophermapPath), includePath)
+}
+
+/* Record dependency on path, noting whether it currently exists */
+func (config *ServerConfig) addDependency(deps map[string]bool, depPath string) {
+    _, err := config.statContent(depPath)
+    deps[depPath] = (err == nil)
+}
+
+func (config *ServerConfig) readIntoGophermap(filePath string) ([]byte, *GophorError) {
+    /* Create return slice */
+    fileContents := make([]byte, 0)
+
+    /* Perform buffered scan with our supplied splitter and iterators */
+    gophorErr := config.bufferedScan(filePath,
+        func(scanner *bufio.Scanner) bool {
+            line := scanner.Text()
+
+            if line == "" {
+                fileContents = append(fileContents, config.buildInfoLine("")...)
+                return true
+            }
+
+            /* Replace the newline character */
+            line = strings.Replace(line, "\n", "", -1)
+
+            /* Reflow line at word boundaries until all lines fit PageWidth */
+            for _, wrapped := range wrapLine(line, config.PageWidth) {
+                fileContents = append(fileContents, config.buildInfoLine(wrapped)...)
+            }
+            
+            return true
+        },
+    )
+
+    /* Check the bufferedScan didn't exit with error */
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+
+    /* Check final output ends on a newline */
+    if !bytes.HasSuffix(fileContents, []byte(DOSLineEnd)) {
+        fileContents = append(fileContents, []byte(DOSLineEnd)...)
+    }
+
+    return fileContents, nil
+}
+
+func replaceStrings(str string, connHost *ConnHost) []byte {
+    str = strings.Replace(str, ReplaceStrHostname, connHost.Name, -1)
+    str = strings.Replace(str, ReplaceStrPort, connHost.Port, -1)
+    return []byte(str)
+}
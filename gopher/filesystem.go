@@ -0,0 +1,588 @@
+package gopher
+
+import (
+    "io"
+    "os"
+    "io/fs"
+    "sync"
+    "path"
+    "time"
+    "strings"
+    "strconv"
+)
+
+type FileType int
+const (
+    /* Leads to some more concise code below */
+    FileTypeRegular FileType = iota
+    FileTypeDir     FileType = iota
+    FileTypeBad     FileType = iota
+)
+
+/* FileSystem:
+ * Object to hold and help manage our file cache. Uses a fixed map
+ * as a means of easily collecting files by path, but also being able
+ * to remove cached files in a LRU style. Uses a RW mutex to lock the
+ * cache map for appropriate functions and ensure thread safety.
+ * All content is read from Source, e.g. a directory on disk, an
+ * embed.FS, a zip.Reader or an in-memory tree.
+ */
+type FileSystem struct {
+    Source       fs.FS
+    CacheMap     *FixedMap
+    CacheMutex   sync.RWMutex
+    CacheFileMax int64
+    ItemTypes    *ItemTypeCache
+    DirConfigs   *DirConfigCache
+
+    config       *ServerConfig
+}
+
+func (fs *FileSystem) Init(config *ServerConfig, source fs.FS, size int, fileSizeMax float64) {
+    fs.Source       = source
+    fs.CacheMap     = NewFixedMap(size)
+    fs.CacheMutex   = sync.RWMutex{}
+    fs.CacheFileMax = int64(BytesInMegaByte * fileSizeMax)
+    fs.ItemTypes    = NewItemTypeCache(config, ItemTypeCacheSize)
+    fs.DirConfigs   = NewDirConfigCache(config, DirConfigCacheSize)
+    fs.config       = config
+}
+
+/* Get item type for regular file at path. Type map entries are trusted,
+ * anything else has its contents sniffed (cached by the item type cache).
+ */
+func (fs *FileSystem) GetItemType(path string, stat os.FileInfo) ItemType {
+    /* Rendered Markdown is served as a menu */
+    if fs.config.RenderMarkdown && isMarkdownFile(path) {
+        return TypeDirectory
+    }
+
+    itemType, ok := fs.config.TypeMap.Lookup(path)
+    if ok {
+        return itemType
+    }
+    return fs.ItemTypes.Get(path, stat)
+}
+
+/* Serve request as a Handler, translating user directory selectors to their path on disk */
+func (fs *FileSystem) Serve(w ResponseWriter, r *Request) {
+    requestPath := fs.config.UserDirs.Resolve(r.Selector)
+
+    gophorErr := fs.HandleRequest(w, &FileSystemRequest{ requestPath, r.Query, r.Host, r.Id, fs.config })
+    if gophorErr != nil {
+        w.WriteError(gophorErr)
+    }
+}
+
+/* Write response for request to w. Most responses are built in full before
+ * being written, archive members and directory archives are streamed to w
+ * as they're read so may fail part way through.
+ */
+func (fs *FileSystem) HandleRequest(w io.Writer, request *FileSystemRequest) *GophorError {
+    output, gophorErr := fs.buildResponse(w, request)
+    if gophorErr != nil {
+        return gophorErr
+    }
+
+    _, err := w.Write(output)
+    if err != nil {
+        return &GophorError{ SocketWriteErr, err }
+    }
+    return nil
+}
+
+/* Build response for request, returning nil output if already streamed to w */
+func (fs *FileSystem) buildResponse(w io.Writer, request *FileSystemRequest) ([]byte, *GophorError) {
+    /* Never serve directory config files */
+    if path.Base(request.Path) == DirConfigFileStr {
+        return nil, &GophorError{ IllegalPathErr, nil }
+    }
+
+    /* Requests for Markdown source are served raw from the original path */
+    serveRaw := false
+    if fs.config.RenderMarkdown && strings.HasSuffix(request.Path, MarkdownRawSuffix) {
+        rawPath := strings.TrimSuffix(request.Path, MarkdownRawSuffix)
+        if isMarkdownFile(rawPath) {
+            request  = &FileSystemRequest{ rawPath, request.Query, request.Host, request.Id, request.config }
+            serveRaw = true
+        }
+    }
+
+    /* Phlog index pages are requested with a page or year query appended */
+    phlogPage, phlogYear := 0, 0
+    if dirPath, page, year, ok := parsePhlogQuery(request.Path); ok {
+        request = &FileSystemRequest{ dirPath, request.Query, request.Host, request.Id, request.config }
+        phlogPage, phlogYear = page, year
+    }
+
+    /* Feeds are generated for directories, unless a real file is in the way */
+    serveFeed := false
+    if path.Base(request.Path) == FeedFileStr {
+        if _, err := fs.config.statContent(request.Path); err != nil {
+            request   = &FileSystemRequest{ path.Dir(request.Path), request.Query, request.Host, request.Id, request.config }
+            serveFeed = true
+        }
+    }
+
+    /* Stat filesystem for request's file type */
+    fileType := FileTypeDir;
+    if request.Path != "/" {
+        stat, err := fs.config.statContent(request.Path)
+        if err != nil {
+            /* Check if this is a path within an archive */
+            if archivePath, memberPath, ok := fs.config.splitArchivePath(request.Path); ok {
+                dirConfig := fs.GetDirConfig(request, path.Dir(archivePath))
+                if dirConfig.Deny {
+                    request.Trace("Access denied by directory config: %s\n", request.Path)
+                    return nil, &GophorError{ IllegalPathErr, nil }
+                }
+                return fs.HandleArchiveRequest(w, request, dirConfig, archivePath, memberPath)
+            }
+
+            /* Check if this is an archive of a directory */
+            if dirPath, ok := fs.config.splitDirArchivePath(request.Path); ok {
+                if fs.GetDirConfig(request, dirPath).Deny {
+                    request.Trace("Access denied by directory config: %s\n", request.Path)
+                    return nil, &GophorError{ IllegalPathErr, nil }
+                }
+                return nil, fs.HandleDirArchiveRequest(w, request, dirPath)
+            }
+
+            /* Check file isn't in cache before throwing in the towel */
+            fs.CacheMutex.RLock()
+            file := fs.CacheMap.Get(request.Path)
+            if file == nil {
+                fs.CacheMutex.RUnlock()
+                request.Trace("Stat failed, not in cache: %s\n", request.Path)
+                return nil, &GophorError{ FileStatErr, err }
+            }
+
+            /* It's there! Get contents, unlock and return */
+            request.Trace("Cache hit (generated): %s\n", request.Path)
+            file.Mutex.RLock()
+            b := file.Contents(request)
+            file.Mutex.RUnlock()
+
+            fs.CacheMutex.RUnlock()
+            return b, nil
+        }
+
+        /* Set file type for later handling */
+        switch {
+            case stat.Mode() & os.ModeDir != 0:
+                /* do nothing, already set :) */
+                break
+
+            case stat.Mode() & os.ModeType == 0:
+                fileType = FileTypeRegular
+
+            default:
+                fileType = FileTypeBad
+        }
+    }
+
+    /* Get directory config for request (parent directory if not a directory itself) */
+    dirPath := request.Path
+    if fileType != FileTypeDir {
+        dirPath = path.Dir(request.Path)
+    }
+    dirConfig := fs.GetDirConfig(request, dirPath)
+    if dirConfig.Deny {
+        request.Trace("Access denied by directory config: %s\n", request.Path)
+        return nil, &GophorError{ IllegalPathErr, nil }
+    }
+
+    /* Phlog queries are only valid for phlog directories */
+    if (phlogPage != 0 || phlogYear != 0) && (fileType != FileTypeDir || !dirConfig.Phlog) {
+        return nil, &GophorError{ FileStatErr, nil }
+    }
+
+    /* Likewise feeds are only available for directories with them enabled */
+    if serveFeed {
+        if fileType != FileTypeDir || !dirConfig.Feed {
+            return nil, &GophorError{ FileStatErr, nil }
+        }
+        request.Trace("Serving feed: %s\n", request.Path)
+        return fs.FetchFeed(request, dirConfig)
+    }
+
+    switch fileType {
+        /* Directory */
+        case FileTypeDir:
+            /* Check Gophermap exists */
+            gophermapPath := path.Join(request.Path, GophermapFileStr)
+            _, err := fs.config.statContent(gophermapPath)
+
+            var output []byte
+            var gophorErr *GophorError
+            if dirConfig.Phlog {
+                /* Phlog, serve generated index with any gophermap as intro */
+                var intro []byte
+                if err == nil && phlogPage <= 1 && phlogYear == 0 {
+                    intro, gophorErr = fs.FetchFile(&FileSystemRequest{ gophermapPath, "", request.Host, request.Id, request.config })
+                    if gophorErr != nil {
+                        return nil, gophorErr
+                    }
+                }
+                if phlogPage == 0 {
+                    phlogPage = 1
+                }
+                request.Trace("Serving phlog index: %s\n", request.Path)
+                output, gophorErr = fs.FetchPhlogIndex(request, dirConfig, phlogPage, phlogYear, intro)
+            } else if err == nil {
+                /* Gophermap exists, serve this! */
+                request.Trace("Serving gophermap: %s\n", gophermapPath)
+                output, gophorErr = fs.FetchFile(&FileSystemRequest{ gophermapPath, "", request.Host, request.Id, request.config })
+            } else {
+                /* No gophermap, serve directory listing */
+                request.Trace("Serving directory listing: %s\n", request.Path)
+                output, gophorErr = fs.config.listDir(request, map[string]bool{})
+            }
+
+            if gophorErr != nil {
+                /* Fail out! */
+                return nil, gophorErr
+            }
+
+            /* Append footer text (contains last line) and return */
+            if dirConfig.Footer != nil {
+                output = append(output, dirConfig.Footer...)
+            } else {
+                output = append(output, fs.config.FooterText...)
+            }
+            return output, nil
+
+        /* Regular file */
+        case FileTypeRegular:
+            if serveRaw {
+                /* Not cached, as the cache holds the rendered contents under this path */
+                request.Trace("Serving raw Markdown: %s\n", request.Path)
+                return fs.config.bufferedRead(request.Path)
+            }
+
+            request.Trace("Serving regular file: %s\n", request.Path)
+            output, gophorErr := fs.FetchFile(request)
+            if gophorErr == nil && dirConfig.Phlog {
+                /* Phlog post, add navigation to neighbouring posts */
+                output = fs.addPhlogNavigation(request, dirConfig, output)
+            }
+            return output, gophorErr
+
+        /* Unsupported type */
+        default:
+            return nil, &GophorError{ FileTypeErr, nil }
+    }
+}
+
+func (fs *FileSystem) FetchFile(request *FileSystemRequest) ([]byte, *GophorError) {
+    var b []byte
+    gophorErr := fs.fetchCached(request, fs.config.newFileContents, func(file *File) {
+        b = file.Contents(request)
+    })
+    return b, gophorErr
+}
+
+/* Create new file contents object appropriate for file at path */
+func (config *ServerConfig) newFileContents(path string) FileContents {
+    if strings.HasSuffix(path, "/"+GophermapFileStr) {
+        return &GophermapContents{ path, nil, nil, config }
+    } else if config.RenderMarkdown && isMarkdownFile(path) {
+        return &MarkdownContents{ path, nil, config }
+    } else {
+        return &RegularFileContents{ path, nil, config }
+    }
+}
+
+/* Fetch file at request path from the cache, loading (and caching) with
+ * contents from the supplied function if not already there. The supplied
+ * use function is called with the file read-locked, so it is safe to read
+ * contents within.
+ */
+func (fs *FileSystem) fetchCached(request *FileSystemRequest, newContents func(string) FileContents, use func(*File)) *GophorError {
+    /* Get cache map read lock then check if file in cache map */
+    fs.CacheMutex.RLock()
+    file := fs.CacheMap.Get(request.Path)
+
+    if file != nil {
+        /* File in cache -- before doing anything get file read lock */
+        file.Mutex.RLock()
+
+        /* Check file is marked as fresh */
+        if !file.Fresh {
+            request.Trace("Cache hit (stale, reloading): %s\n", request.Path)
+
+            /* File not fresh! Swap file read for write-lock */
+            file.Mutex.RUnlock()
+            file.Mutex.Lock()
+
+            /* Reload file contents from disk */
+            gophorErr := file.LoadContents()
+            if gophorErr != nil {
+                /* Error loading contents, unlock all mutex then return error */
+                file.Mutex.Unlock()
+                fs.CacheMutex.RUnlock()
+                return gophorErr
+            }
+
+            /* Updated! Swap back file write for read lock */
+            file.Mutex.Unlock()
+            file.Mutex.RLock()
+        } else {
+            request.Trace("Cache hit: %s\n", request.Path)
+        }
+    } else {
+        request.Trace("Cache miss: %s\n", request.Path)
+
+        /* Perform filesystem stat ready for checking file size later.
+         * Doing this now allows us to weed-out non-existent files early
+         */
+        stat, err := fs.config.statContent(cacheKeyPath(request.Path))
+        if err != nil {
+            /* Error stat'ing file, unlock read mutex then return error */
+            fs.CacheMutex.RUnlock()
+            return &GophorError{ FileStatErr, err }
+        }
+
+        /* Create new file wrapper around contents from supplied function */
+        file = NewFile(newContents(cacheKeyPath(request.Path)))
+
+        /* File isn't in cache yet so no need to get file lock mutex */
+        gophorErr := file.LoadContents()
+        if gophorErr != nil {
+            /* Error loading contents, unlock read mutex then return error */
+            fs.CacheMutex.RUnlock()
+            return gophorErr
+        }
+
+        /* Compare file size (in MB) to CacheFileSizeMax, if larger just get file
+         * contents, unlock all mutex and don't bother caching. Views of a file
+         * hold derived data rather than its contents, so are cached whatever
+         * the file size (unless caching is disabled).
+         */
+        if stat.Size() > fs.CacheFileMax && !(isCacheViewKey(request.Path) && fs.CacheFileMax > 0) {
+            request.Trace("Not caching, size %d > max %d: %s\n", stat.Size(), fs.CacheFileMax, request.Path)
+            use(file)
+            fs.CacheMutex.RUnlock()
+            return nil
+        }
+
+        /* File not in cache -- Swap cache map read for write lock. */
+        fs.CacheMutex.RUnlock()
+        fs.CacheMutex.Lock()
+
+        /* Put file in the FixedMap */
+        if popped := fs.CacheMap.Put(request.Path, file); popped != "" {
+            fs.config.LogSystemDebug("Popped key: %s\n", popped)
+        }
+
+        /* Before unlocking cache mutex, lock file read for upcoming call to .Contents() */
+        file.Mutex.RLock()
+
+        /* Swap cache lock back to read */
+        fs.CacheMutex.Unlock()
+        fs.CacheMutex.RLock()
+    }
+
+    /* Use file contents, then unlock file read lock */
+    use(file)
+    file.Mutex.RUnlock()
+
+    /* Finally we can unlock the cache map read lock, we are done :) */
+    fs.CacheMutex.RUnlock()
+
+    return nil
+}
+
+/* Get cache key for named alternate view of the file at path (e.g. an
+ * archive's index). Paths can't contain CacheViewSep, so these never
+ * clash with the key of the file itself.
+ */
+func cacheViewKey(filePath, view string) string {
+    return filePath+CacheViewSep+view
+}
+
+func isCacheViewKey(key string) bool {
+    return strings.Contains(key, CacheViewSep)
+}
+
+/* Get path of the file a cache key refers to */
+func cacheKeyPath(key string) string {
+    if i := strings.Index(key, CacheViewSep); i >= 0 {
+        return key[:i]
+    }
+    return key
+}
+
+/* Get key for caching something derived from the current version of the
+ * file at path, changing whenever its modification time or size does
+ */
+func statCacheKey(path string, stat os.FileInfo) string {
+    return path+CacheViewSep+strconv.FormatInt(stat.ModTime().UnixNano(), 10)+CacheViewSep+strconv.FormatInt(stat.Size(), 10)
+}
+
+/* FileSystemRequest:
+ * Makes a request to the filesystem either through
+ * the FileCache or directly to a function like listDir().
+ * It carries the requested filesystem path and any extra
+ * needed information, for the moment the tab-separated search
+ * query sent with the selector (if any), a set of details
+ * about the virtual host and the ID of the connection it
+ * originated from (used to tag debug traces). Opens things
+ * up a lot more for the future :)
+ */
+type FileSystemRequest struct {
+    Path   string
+    Query  string
+    Host   *ConnHost
+    Id     uint64
+
+    config *ServerConfig
+}
+
+func (r *FileSystemRequest) Trace(format string, args ...interface{}) {
+    if r.config == nil {
+        return
+    }
+    r.config.LogTrace(r.Id, format, args...)
+}
+
+/* File:
+ * Wraps around the cached contents of a file and
+ * helps with management of this content by the
+ * global FileCache objects.
+ */
+type File struct {
+    contents    FileContents
+    Mutex       sync.RWMutex
+    Fresh       bool
+    LastRefresh int64
+}
+
+func NewFile(contents FileContents) *File {
+    return &File{ 
+        contents,
+        sync.RWMutex{},
+        true,
+        0,
+    }
+}
+
+func (f *File) Contents(request *FileSystemRequest) []byte {
+    return f.contents.Render(request)
+}
+
+func (f *File) LoadContents() *GophorError {
+    /* Clear current file contents */
+    f.contents.Clear()
+
+    /* Reload the file */
+    gophorErr := f.contents.Load()
+    if gophorErr != nil {
+        return gophorErr
+    }
+
+    /* Update lastRefresh, set fresh, unset deletion (not likely set) */
+    f.LastRefresh = time.Now().UnixNano()
+    f.Fresh       = true
+
+    return nil
+}
+
+/* FileContents:
+ * Interface that provides an adaptable implementation
+ * for holding onto some level of information about
+ * the contents of a file, also methods for processing
+ * and returning the results when the file contents
+ * are requested.
+ */
+type FileContents interface {
+    Render(*FileSystemRequest) []byte
+    Load()                     *GophorError
+    Clear()
+}
+
+func (config *ServerConfig) startFileMonitor(sleepTime time.Duration, stop <-chan struct{}) {
+    go func() {
+        for {
+            /* Sleep so we don't take up all the precious CPU time :) */
+            select {
+                case <-time.After(sleepTime):
+                case <-stop:
+                    return
+            }
+
+            /* Check global file cache freshness */
+            config.checkCacheFreshness()
+        }
+    }()
+}
+
+func (config *ServerConfig) checkCacheFreshness() {
+    /* Before anything, get cache write lock (in case we have to delete) */
+    config.FileSystem.CacheMutex.Lock()
+
+    /* Iterate through paths in cache map to query file last modified times */
+    for path := range config.FileSystem.CacheMap.Map {
+        /* Get file pointer, no need for lock as we have write lock */
+        file := config.FileSystem.CacheMap.Get(path)
+
+        /* If this is a generated file, we skip */
+        if isGeneratedType(file) {
+            continue
+        }
+
+        stat, err := config.statContent(cacheKeyPath(path))
+        if err != nil {
+            /* Log file as not in cache, then delete */
+            config.LogSystemWarn("Failed to stat file in cache: %s\n", path)
+            config.FileSystem.CacheMap.Remove(path)
+            continue
+        }
+        timeModified := stat.ModTime().UnixNano()
+
+        /* If the file is marked as fresh, but file on disk newer, mark as unfresh */
+        if file.Fresh && file.LastRefresh < timeModified {
+            file.Fresh = false
+        }
+
+        /* If still fresh, check whether any files it depends on have changed */
+        if file.Fresh && !config.isDependenciesFresh(file) {
+            config.LogSystemDebug("Dependency changed, marking unfresh: %s\n", path)
+            file.Fresh = false
+        }
+    }
+
+    /* Done! We can release cache read lock */
+    config.FileSystem.CacheMutex.Unlock()
+}
+
+/* Check all dependencies of file (if any) are unchanged since last refresh */
+func (config *ServerConfig) isDependenciesFresh(file *File) bool {
+    dependent, ok := file.contents.(DependentContents)
+    if !ok {
+        return true
+    }
+
+    for depPath, existed := range dependent.Dependencies() {
+        stat, err := config.statContent(depPath)
+        if (err == nil) != existed {
+            /* Dependency appeared or disappeared */
+            return false
+        } else if err == nil && file.LastRefresh < stat.ModTime().UnixNano() {
+            /* Dependency modified */
+            return false
+        }
+    }
+
+    return true
+}
+
+func isGeneratedType(file *File) bool {
+    /* Just a helper function to neaten-up checking if file contents is of generated type */
+    switch file.contents.(type) {
+       case *GeneratedFileContents:
+           return true
+       default:
+           return false 
+    }
+}
@@ -0,0 +1,444 @@
+package gopher
+
+import (
+    "os"
+    "path"
+    "bytes"
+    "io"
+    "io/fs"
+    "sort"
+    "bufio"
+    "strings"
+    "path/filepath"
+)
+
+/* Content is accessed through the FileSystem's source, using absolute
+ * paths from the root of the source (just as they are when chroot'd)
+ */
+func sourcePath(filePath string) string {
+    filePath = strings.TrimPrefix(path.Clean("/"+filePath), "/")
+    if filePath == "" {
+        return "."
+    }
+    return filePath
+}
+
+/* Stat content at path, following symlinks as os.Stat() */
+func (config *ServerConfig) statContent(filePath string) (os.FileInfo, error) {
+    return fs.Stat(config.FileSystem.Source, sourcePath(filePath))
+}
+
+func (config *ServerConfig) openContent(filePath string) (fs.File, error) {
+    return config.FileSystem.Source.Open(sourcePath(filePath))
+}
+
+/* Read info of each entry in content directory at path */
+func (config *ServerConfig) readContentDir(dirPath string) ([]os.FileInfo, error) {
+    entries, err := fs.ReadDir(config.FileSystem.Source, sourcePath(dirPath))
+    if err != nil {
+        return nil, err
+    }
+
+    files := make([]os.FileInfo, 0, len(entries))
+    for _, entry := range entries {
+        file, err := entry.Info()
+        if err != nil {
+            /* Removed since directory was read */
+            continue
+        }
+        files = append(files, file)
+    }
+    return files, nil
+}
+
+/* Perform simple buffered read on content at path */
+func (config *ServerConfig) bufferedRead(path string) ([]byte, *GophorError) {
+    /* Open file */
+    fd, err := config.openContent(path)
+    if err != nil {
+        return nil, &GophorError{ FileOpenErr, err }
+    }
+    defer fd.Close()
+
+    return bufferedReadFrom(fd)
+}
+
+/* Perform simple buffered read on file at path on the real filesystem, e.g. config files */
+func bufferedReadFile(path string) ([]byte, *GophorError) {
+    /* Open file */
+    fd, err := os.Open(path)
+    if err != nil {
+        return nil, &GophorError{ FileOpenErr, err }
+    }
+    defer fd.Close()
+
+    return bufferedReadFrom(fd)
+}
+
+func bufferedReadFrom(fd io.Reader) ([]byte, *GophorError) {
+    /* Setup buffers */
+    var count int
+    var err error
+    contents := make([]byte, 0)
+    buf := make([]byte, FileReadBufSize)
+
+    /* Setup reader */
+    reader := bufio.NewReader(fd)
+
+    /* Read through buffer until EOF. Short reads don't mean EOF, some
+     * sources (e.g. compressed) return less than requested
+     */
+    for {
+        count, err = reader.Read(buf)
+        contents = append(contents, buf[:count]...)
+        if err != nil {
+            if err == io.EOF {
+                break
+            }
+
+            return nil, &GophorError{ FileReadErr, err }
+        }
+    }
+
+    return contents, nil
+}
+
+/* Perform buffered read on content at path, then scan through with supplied iterator func */
+func (config *ServerConfig) bufferedScan(path string, scanIterator func(*bufio.Scanner) bool) *GophorError {
+    /* First, read raw file contents */
+    contents, gophorErr := config.bufferedRead(path)
+    if gophorErr != nil {
+        return gophorErr
+    }
+    return scanContents(contents, scanIterator)
+}
+
+/* Perform buffered read on file at path on the real filesystem, then scan through with supplied iterator func */
+func bufferedScanFile(path string, scanIterator func(*bufio.Scanner) bool) *GophorError {
+    /* First, read raw file contents */
+    contents, gophorErr := bufferedReadFile(path)
+    if gophorErr != nil {
+        return gophorErr
+    }
+    return scanContents(contents, scanIterator)
+}
+
+/* reloadableFile:
+ * A user supplied file on the real filesystem that can be re-read while
+ * running. Its directory is opened on first read (before any chroot) and
+ * the file re-read through that handle, so it stays reachable after
+ * chroot'ing into the server root.
+ */
+type reloadableFile struct {
+    path string
+    dir  *os.Root
+}
+
+/* Perform buffered scan on the file, as bufferedScanFile() */
+func (rf *reloadableFile) scan(scanIterator func(*bufio.Scanner) bool) *GophorError {
+    if rf.dir == nil {
+        dir, err := os.OpenRoot(filepath.Dir(rf.path))
+        if err != nil {
+            return &GophorError{ FileOpenErr, err }
+        }
+        rf.dir = dir
+    }
+
+    fd, err := rf.dir.Open(filepath.Base(rf.path))
+    if err != nil {
+        return &GophorError{ FileOpenErr, err }
+    }
+    defer fd.Close()
+
+    contents, gophorErr := bufferedReadFrom(fd)
+    if gophorErr != nil {
+        return gophorErr
+    }
+    return scanContents(contents, scanIterator)
+}
+
+func scanContents(contents []byte, scanIterator func(*bufio.Scanner) bool) *GophorError {
+    /* Create reader and scanner from this */
+    reader := bytes.NewReader(contents)
+    scanner := bufio.NewScanner(reader)
+
+    /* If contains DOS line-endings, split by DOS! Else, split by Unix */
+    if bytes.Contains(contents, []byte(DOSLineEnd)) {
+        scanner.Split(dosLineEndSplitter)
+    } else {
+        scanner.Split(unixLineEndSplitter)
+    }
+
+    /* Scan through file contents using supplied iterator */
+    for scanner.Scan() && scanIterator(scanner) {}
+
+    /* Check scanner finished cleanly */
+    if scanner.Err() != nil {
+        return &GophorError{ FileReadErr, scanner.Err() }
+    }
+
+    return nil
+}
+
+func dosLineEndSplitter(data []byte, atEOF bool) (advance int, token []byte, err error) {
+    if atEOF && len(data) == 0  {
+        /* At EOF, no more data */
+        return 0, nil, nil
+    }
+
+    if i := bytes.Index(data, []byte("\r\n")); i >= 0 {
+        /* We have a full new-line terminate line */
+        return i+2, data[:i], nil
+    }
+
+    if atEOF {
+        /* Final line without line ending */
+        return len(data), data, nil
+    }
+
+    /* Request more data */
+    return 0, nil, nil
+}
+
+func unixLineEndSplitter(data []byte, atEOF bool) (advance int, token []byte, err error) {
+    if atEOF && len(data) == 0  {
+        /* At EOF, no more data */
+        return 0, nil, nil
+    }
+
+    if i := bytes.Index(data, []byte("\n")); i >= 0 {
+        /* We have a full new-line terminate line */
+        return i+1, data[:i], nil
+    }
+
+    if atEOF {
+        /* Final line without line ending */
+        return len(data), data, nil
+    }
+
+    /* Request more data */
+    return 0, nil, nil
+}
+
+/* listDir():
+ * Here we use an empty function pointer on the ServerConfig, and set
+ * the correct function to be used during the restricted files regex
+ * parsing. This negates need to check if RestrictedFilesRegex is nil
+ * every single call.
+ */
+
+func (config *ServerConfig) _listDir(request *FileSystemRequest, hidden map[string]bool) ([]byte, *GophorError) {
+    return config._listDirBase(request, func(dirContents *[]byte, file os.FileInfo, dirConfig *DirConfig) {
+        /* If requested hidden */
+        if _, ok := hidden[file.Name()]; ok {
+            return
+        }
+
+        /* Handle file, directory or ignore others */
+        switch {
+            case file.Mode() & os.ModeDir != 0:
+                /* Directory -- create directory listing */
+                itemPath := path.Join(request.Path, file.Name())
+                *dirContents = append(*dirContents, config.buildLine(TypeDirectory, config.formatListName(file, dirConfig.Style), config.UserDirs.Selector(itemPath), request.Host.Name, request.Host.Port)...)
+
+            case file.Mode() & os.ModeType == 0:
+                /* Regular file -- find item type and creating listing */
+                itemPath := path.Join(request.Path, file.Name())
+                itemType, ok := dirConfig.ItemType(file.Name())
+                if !ok {
+                    itemType = config.FileSystem.GetItemType(itemPath, file)
+                }
+                *dirContents = append(*dirContents, config.buildLine(itemType, config.formatListName(file, dirConfig.Style), config.UserDirs.Selector(itemPath), request.Host.Name, request.Host.Port)...)
+
+                /* Browsable archives can also be opened as a menu */
+                if itemType == TypeBinArchive && isArchiveFile(itemPath) {
+                    *dirContents = append(*dirContents, config.buildArchiveBrowseLine(file, config.UserDirs.Selector(itemPath), dirConfig, request.Host)...)
+                }
+
+            default:
+                /* Ignore */
+        }
+    })
+}
+
+func (config *ServerConfig) _listDirRegexMatch(request *FileSystemRequest, hidden map[string]bool) ([]byte, *GophorError) {
+    return config._listDirBase(request, func(dirContents *[]byte, file os.FileInfo, dirConfig *DirConfig) {
+        /* If regex match in restricted files || requested hidden */
+        if config.isRestrictedFile(file.Name()) {
+            return
+        } else if _, ok := hidden[file.Name()]; ok {
+            return
+        }
+
+        /* Handle file, directory or ignore others */
+        switch {
+            case file.Mode() & os.ModeDir != 0:
+                /* Directory -- create directory listing */
+                itemPath := path.Join(request.Path, file.Name())
+                *dirContents = append(*dirContents, config.buildLine(TypeDirectory, config.formatListName(file, dirConfig.Style), config.UserDirs.Selector(itemPath), request.Host.Name, request.Host.Port)...)
+
+            case file.Mode() & os.ModeType == 0:
+                /* Regular file -- find item type and creating listing */
+                itemPath := path.Join(request.Path, file.Name())
+                itemType, ok := dirConfig.ItemType(file.Name())
+                if !ok {
+                    itemType = config.FileSystem.GetItemType(itemPath, file)
+                }
+                *dirContents = append(*dirContents, config.buildLine(itemType, config.formatListName(file, dirConfig.Style), config.UserDirs.Selector(itemPath), request.Host.Name, request.Host.Port)...)
+
+                /* Browsable archives can also be opened as a menu */
+                if itemType == TypeBinArchive && isArchiveFile(itemPath) {
+                    *dirContents = append(*dirContents, config.buildArchiveBrowseLine(file, config.UserDirs.Selector(itemPath), dirConfig, request.Host)...)
+                }
+
+            default:
+                /* Ignore */
+        }
+    })
+}
+
+func (config *ServerConfig) _listDirBase(request *FileSystemRequest, iterFunc func(dirContents *[]byte, file os.FileInfo, dirConfig *DirConfig)) ([]byte, *GophorError) {
+    /* Read files in directory */
+    files, err := config.readContentDir(request.Path)
+    if err != nil {
+        config.LogSystemError("failed to enumerate dir %s: %s\n", request.Path, err.Error())
+        return nil, &GophorError{ DirListErr, err }
+    }
+
+    /* Get directory config for hidden entries, forced types, sorting + title */
+    dirConfig := config.FileSystem.GetDirConfig(request, request.Path)
+
+    /* Sort the files by requested order */
+    sortFiles(files, dirConfig.Sort, dirConfig.DirsFirst)
+
+    /* Create directory content slice, ready */
+    dirContents := make([]byte, 0)
+
+    /* First add a title + a space */
+    selector := config.UserDirs.Selector(request.Path)
+    title := "[ "+request.Host.Name+selector+" ]"
+    if dirConfig.Title != "" {
+        title = dirConfig.Title
+    }
+    dirContents = append(dirContents, config.buildLine(TypeInfo, title, "TITLE", NullHost, NullPort)...)
+    dirContents = append(dirContents, config.buildInfoLine("")...)
+
+    /* Add a 'back' entry. GoLang ReadDir() seems to miss this */
+    dirContents = append(dirContents, config.buildLine(TypeDirectory, "..", path.Join(selector, ".."), request.Host.Name, request.Host.Port)...)
+
+    /* Add feed link if enabled */
+    if dirConfig.Feed {
+        dirContents = append(dirContents, config.buildFeedLine(selector, request.Host)...)
+    }
+
+    /* Add directory archive download if enabled */
+    if config.DirArchiveExt != "" {
+        dirContents = append(dirContents, config.buildLine(TypeBinArchive, "Download all ("+config.DirArchiveExt+")", config.dirArchiveSelector(selector), request.Host.Name, request.Host.Port)...)
+    }
+
+    /* Walk through files, skipping those hidden by directory config :D */
+    for _, file := range files {
+        if dirConfig.IsHidden(file.Name()) {
+            continue
+        }
+        iterFunc(&dirContents, file, dirConfig)
+    }
+
+    return dirContents, nil
+}
+
+/* ListSort:
+ * Order in which directory listing entries are sorted.
+ * Unset means inherit from parent directory config, else
+ * fall back to the server default.
+ */
+type ListSort int
+const (
+    ListSortUnset        ListSort = iota
+    ListSortName         ListSort = iota
+    ListSortNameReverse  ListSort = iota
+    ListSortMtime        ListSort = iota
+    ListSortMtimeReverse ListSort = iota
+    ListSortSize         ListSort = iota
+    ListSortSizeReverse  ListSort = iota
+)
+
+/* Parse user supplied list sort string. This is a sort key, optionally
+ * followed by 'dirs-first' to list directories before files.
+ */
+func parseListSort(str string) (ListSort, bool, bool) {
+    fields := strings.Fields(strings.ToLower(str))
+    if len(fields) == 0 || len(fields) > 2 {
+        return ListSortUnset, false, false
+    }
+
+    dirsFirst := false
+    if len(fields) == 2 {
+        if fields[1] != "dirs-first" {
+            return ListSortUnset, false, false
+        }
+        dirsFirst = true
+    }
+
+    switch fields[0] {
+        case "name":
+            return ListSortName, dirsFirst, true
+        case "name-reverse":
+            return ListSortNameReverse, dirsFirst, true
+        case "mtime", "oldest":
+            return ListSortMtime, dirsFirst, true
+        case "mtime-reverse", "newest":
+            return ListSortMtimeReverse, dirsFirst, true
+        case "size":
+            return ListSortSize, dirsFirst, true
+        case "size-reverse":
+            return ListSortSizeReverse, dirsFirst, true
+        default:
+            return ListSortUnset, false, false
+    }
+}
+
+/* Sort files in place according to list sort, then if requested
+ * move directories to the front (keeping their sorted order).
+ */
+func sortFiles(files []os.FileInfo, listSort ListSort, dirsFirst bool) {
+    switch listSort {
+        case ListSortNameReverse:
+            sort.Sort(sort.Reverse(byName(files)))
+        case ListSortMtime:
+            sort.Stable(byModTime(files))
+        case ListSortMtimeReverse:
+            sort.Stable(sort.Reverse(byModTime(files)))
+        case ListSortSize:
+            sort.Stable(bySize(files))
+        case ListSortSizeReverse:
+            sort.Stable(sort.Reverse(bySize(files)))
+        default:
+            sort.Sort(byName(files))
+    }
+
+    if dirsFirst {
+        sort.Stable(byDirsFirst(files))
+    }
+}
+
+/* Took a leaf out of go-gopher's book here. */
+type byName []os.FileInfo
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type byModTime []os.FileInfo
+func (s byModTime) Len() int           { return len(s) }
+func (s byModTime) Less(i, j int) bool { return s[i].ModTime().Before(s[j].ModTime()) }
+func (s byModTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type bySize []os.FileInfo
+func (s bySize) Len() int           { return len(s) }
+func (s bySize) Less(i, j int) bool { return s[i].Size() < s[j].Size() }
+func (s bySize) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type byDirsFirst []os.FileInfo
+func (s byDirsFirst) Len() int           { return len(s) }
+func (s byDirsFirst) Less(i, j int) bool { return s[i].IsDir() && !s[j].IsDir() }
+func (s byDirsFirst) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
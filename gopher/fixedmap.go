@@ -1,4 +1,4 @@
-package main
+package gopher
 
 import (
     "container/list"
@@ -45,8 +45,8 @@ func (fm *FixedMap) Get(key string) *File {
 }
 
 /* Put file in map as key, pushing out last file
- * if size limit reached */
-func (fm *FixedMap) Put(key string, value *File) {
+ * if size limit reached. Returns key pushed out, or "" */
+func (fm *FixedMap) Put(key string, value *File) string {
     element := fm.List.PushFront(key)
     fm.Map[key] = &MapElement{ element, value }
 
@@ -60,9 +60,9 @@ func (fm *FixedMap) Put(key string, value *File) {
         /* Finally delete the map entry and list element! */
         delete(fm.Map, key)
         fm.List.Remove(element)
-
-        Config.LogSystem("Popped key: %s\n", key)
+        return key
     }
+    return ""
 }
 
 /* Try delete element, else do nothing */
@@ -1,6 +1,8 @@
-package main
+package gopher
 
 import (
+    "os"
+    "fmt"
     "strings"
 )
 
@@ -17,6 +19,14 @@ var FileExtMap = map[string]ItemType{
     ".bz2":          TypeBinArchive,
     ".7z":           TypeBinArchive,
     ".zip":          TypeBinArchive,
+    ".xz":           TypeBinArchive,
+    ".zst":          TypeBinArchive,
+    ".rar":          TypeBinArchive,
+    ".tar":          TypeBinArchive,
+    ".tgz":          TypeBinArchive,
+    ".tar.gz":       TypeBinArchive,
+    ".tar.bz2":      TypeBinArchive,
+    ".tar.xz":       TypeBinArchive,
 
     ".gitignore":    TypeFile,
     ".txt":          TypeFile,
@@ -89,15 +99,11 @@ func buildError(selector string) []byte {
 }
 
 /* Build gopher compliant line with supplied information */
-func buildLine(t ItemType, name, selector, host string, port string) []byte {
+func (config *ServerConfig) buildLine(t ItemType, name, selector, host string, port string) []byte {
     ret := string(t)
 
-    /* Add name, truncate name if too long */    
-    if len(name) > Config.PageWidth {
-        ret += name[:Config.PageWidth-5]+"...\t"
-    } else {
-        ret += name+"\t"
-    }
+    /* Add name, truncate name if too wide */
+    ret += truncateName(name, config.PageWidth)+"\t"
 
     /* Add selector. If too long use err, skip if empty */
     selectorLen := len(selector)
@@ -114,31 +120,98 @@ func buildLine(t ItemType, name, selector, host string, port string) []byte {
 }
 
 /* Build gopher compliant info line */
-func buildInfoLine(content string) []byte {
-    return buildLine(TypeInfo, content, NullSelector, NullHost, NullPort)
+func (config *ServerConfig) buildInfoLine(content string) []byte {
+    return config.buildLine(TypeInfo, content, NullSelector, NullHost, NullPort)
 }
 
-/* Get item type for named file on disk */
-func getItemType(name string) ItemType {
-    /* Split, name MUST be lower */
-    split := strings.Split(strings.ToLower(name), ".")
+/* Get item type for named file from the type map, or TypeDefault */
+func (config *ServerConfig) getItemType(name string) ItemType {
+    itemType, ok := config.TypeMap.Lookup(name)
+    if !ok {
+        return TypeDefault
+    }
+    return itemType
+}
 
-    /* First we look at how many '.' in name string */
-    splitLen := len(split)
-    switch splitLen {
-        case 0:
-            /* Always return TypeDefault. We can never tell */
-            return TypeDefault
+/* ListStyle:
+ * How entry names are displayed in directory listings.
+ * Unset means inherit from parent directory config, else
+ * fall back to the server default.
+ */
+type ListStyle int
+const (
+    ListStyleUnset   ListStyle = iota
+    ListStylePlain   ListStyle = iota
+    ListStyleSuffix  ListStyle = iota
+    ListStyleColumns ListStyle = iota
+)
 
+/* Parse user supplied list style string */
+func parseListStyle(str string) (ListStyle, bool) {
+    switch strings.ToLower(strings.TrimSpace(str)) {
+        case "plain":
+            return ListStylePlain, true
+        case "suffix":
+            return ListStyleSuffix, true
+        case "columns":
+            return ListStyleColumns, true
         default:
-            /* Get index of str after last ".", look in FileExtMap */
-            fileType, ok := FileExtMap["."+split[splitLen-1]]
-            if ok {
-                return fileType
-            } else {
-                return TypeDefault
+            return ListStyleUnset, false
+    }
+}
+
+/* Format the display name of a directory listing entry in the
+ * requested style, truncating the name so that size and date
+ * information still fits within PageWidth.
+ */
+func (config *ServerConfig) formatListName(file os.FileInfo, listStyle ListStyle) string {
+    name := file.Name()
+
+    /* Directories don't get a meaningful size */
+    size := "-"
+    if !file.IsDir() {
+        size = formatFileSize(file.Size())
+    }
+    date := file.ModTime().Format(ListDateFormat)
+
+    switch listStyle {
+        case ListStyleSuffix:
+            suffix := " ("+size+", "+date+")"
+            return truncateName(name, config.PageWidth-stringWidth(suffix))+suffix
+
+        case ListStyleColumns:
+            columns := fmt.Sprintf("  %6s  %s", size, date)
+            width := config.PageWidth-len(columns)
+            if width < MinListNameWidth {
+                /* Not enough room for columns, fall back to suffix */
+                return config.formatListName(file, ListStyleSuffix)
             }
+            return padToWidth(truncateName(name, width), width)+columns
+
+        default:
+            return name
+    }
+}
+
+/* Build gopher URL pointing to item, for use outside of menus */
+func buildGopherUrl(t ItemType, selector, host, port string) string {
+    return "gopher://"+host+":"+port+"/"+string(t)+selector
+}
+
+/* Format file size in bytes as human-readable string */
+func formatFileSize(size int64) string {
+    units := "BKMGTPE"
+    value := float64(size)
+    i := 0
+    for value >= 1024 && i < len(units)-1 {
+        value /= 1024
+        i += 1
+    }
+
+    if i == 0 {
+        return fmt.Sprintf("%d%c", size, units[i])
     }
+    return fmt.Sprintf("%.1f%c", value, units[i])
 }
 
 /* Build a line separator of supplied width */
@@ -151,15 +224,15 @@ func buildLineSeparator(count int) string {
 }
 
 /* Formats an info-text footer from string. Add last line as we use the footer to contain last line (regardless if empty) */
-func formatGophermapFooter(text string, useSeparator bool) []byte {
+func (config *ServerConfig) formatGophermapFooter(text string, useSeparator bool) []byte {
     ret := make([]byte, 0)
     if text != "" {
-        ret = append(ret, buildInfoLine("")...)
+        ret = append(ret, config.buildInfoLine("")...)
         if useSeparator {
-            ret = append(ret, buildInfoLine(buildLineSeparator(Config.PageWidth))...)
+            ret = append(ret, config.buildInfoLine(buildLineSeparator(config.PageWidth))...)
         }
         for _, line := range strings.Split(text, "\n") {
-            ret = append(ret, buildInfoLine(line)...)
+            ret = append(ret, config.buildInfoLine(line)...)
         }
     }
     ret = append(ret, []byte(LastLine)...)
@@ -185,6 +258,8 @@ func parseLineType(line string) ItemType {
                 return TypeInfo
             case TypeTitle:
                 return TypeTitle
+            case TypeUserList:
+                return TypeUserList
             default:
                 return TypeUnknown
         }
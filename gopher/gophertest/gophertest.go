@@ -0,0 +1,170 @@
+/* Package gophertest provides an in-process gophor server for tests.
+ *
+ * A Server runs the full gophor server (gophermaps, caching, rewrite
+ * rules and so on) on an ephemeral loopback port, against a temporary
+ * directory, with no chroot or dropping of privileges. Helpers request
+ * selectors and compare the responses, failing the test on mismatch.
+ * Each Server is independent, so tests using them may run in parallel.
+ */
+package gophertest
+
+import (
+    "os"
+    "net"
+    "strconv"
+    "testing"
+    "path/filepath"
+    "github.com/EaterLabs/gophor/gopher"
+    "github.com/EaterLabs/gophor/gopher/client"
+)
+
+/* Server:
+ * A running gophor server, serving the directory Dir on Host:Port.
+ * It is closed when the test completes.
+ */
+type Server struct {
+    *gopher.Server
+
+    Dir    string
+    Host   string
+    Port   string
+    Client *client.Client
+
+    t      testing.TB
+}
+
+/* Start a server for test t. If options is nil the defaults are used,
+ * with logging and the file cache disabled (so files written during the
+ * test are served straight away). Unless options sets a Source, content
+ * is served from RootDir, which is set to a new temporary directory.
+ * Host, port and bind address are always overridden.
+ */
+func NewServer(t testing.TB, options *gopher.ServerOptions) *Server {
+    t.Helper()
+
+    if options == nil {
+        options = gopher.DefaultServerOptions()
+        options.LogType       = 1
+        options.CacheDisabled = true
+    }
+    if options.Source == nil {
+        options.RootDir = t.TempDir()
+    }
+    options.Hostname = "127.0.0.1"
+    options.BindAddr = "127.0.0.1"
+    options.Port     = 0
+
+    gopherServer, gophorErr := gopher.NewServer(options)
+    if gophorErr != nil {
+        t.Fatalf("Error creating server: %s", gophorErr.Error())
+    }
+
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Error listening: %s", err.Error())
+    }
+    go gopherServer.Serve(l)
+    t.Cleanup(gopherServer.Close)
+
+    return &Server{
+        gopherServer,
+        options.RootDir,
+        "127.0.0.1",
+        strconv.Itoa(l.Addr().(*net.TCPAddr).Port),
+        &client.Client{ Timeout: client.DefaultTimeout },
+        t,
+    }
+}
+
+/* Write file under the server's directory, creating any parent directories */
+func (s *Server) WriteFile(name, contents string) {
+    s.t.Helper()
+
+    path := filepath.Join(s.Dir, filepath.FromSlash(name))
+    err := os.MkdirAll(filepath.Dir(path), 0755)
+    if err == nil {
+        err = os.WriteFile(path, []byte(contents), 0644)
+    }
+    if err != nil {
+        s.t.Fatalf("Error writing %s: %s", name, err.Error())
+    }
+}
+
+/* Get URL of item with type and selector on this server */
+func (s *Server) URL(t gopher.ItemType, selector string) *client.URL {
+    return &client.URL{ Host: s.Host, Port: s.Port, Type: t, Selector: selector }
+}
+
+/* Request selector, failing the test if the request fails */
+func (s *Server) Get(selector string) *client.Response {
+    s.t.Helper()
+    return s.GetQuery(selector, "")
+}
+
+/* Request selector with search query, failing the test if the request fails */
+func (s *Server) GetQuery(selector, query string) *client.Response {
+    s.t.Helper()
+
+    u := s.URL(gopher.TypeDirectory, selector)
+    u.Query = query
+    response, err := s.Client.Fetch(u)
+    if err != nil {
+        s.t.Fatalf("Error fetching %s: %s", selector, err.Error())
+    }
+    return response
+}
+
+/* Check selector is served as text (with any '.' last line removed) equal to want */
+func (s *Server) AssertText(selector, want string) {
+    s.t.Helper()
+
+    got := s.Get(selector).Text()
+    if got != want {
+        s.t.Errorf("Text of %s:\ngot:\n%s\nwant:\n%s", selector, got, want)
+    }
+}
+
+/* Check selector is served as a menu matching want, itself a menu (lines
+ * may end '\n' or '\r\n'). Type and display string are always compared,
+ * selector, host and port only where want's line has them non-empty.
+ */
+func (s *Server) AssertMenu(selector, want string) {
+    s.t.Helper()
+
+    response := s.Get(selector)
+    if message := response.ServerError(); message != "" {
+        s.t.Errorf("Menu %s: got error: %s", selector, message)
+        return
+    }
+
+    gotItems, wantItems := response.Menu(), client.ParseMenu([]byte(want))
+    for i := 0; i < len(gotItems) || i < len(wantItems); i += 1 {
+        switch {
+            case i >= len(wantItems):
+                s.t.Errorf("Menu %s line %d: unexpected %q", selector, i+1, gotItems[i].String())
+            case i >= len(gotItems):
+                s.t.Errorf("Menu %s line %d: missing %q", selector, i+1, wantItems[i].String())
+            case !itemMatches(gotItems[i], wantItems[i]):
+                s.t.Errorf("Menu %s line %d:\ngot:  %q\nwant: %q", selector, i+1, gotItems[i].String(), wantItems[i].String())
+        }
+    }
+}
+
+/* Check selector is answered with the error response for code */
+func (s *Server) AssertError(selector string, code gopher.ErrorResponseCode) {
+    s.t.Helper()
+
+    got := s.Get(selector).ServerError()
+    if got != code.String() {
+        s.t.Errorf("Error for %s: got %q, want %q", selector, got, code.String())
+    }
+}
+
+/* Check got matches want, skipping want's empty link fields */
+func itemMatches(got, want *client.Item) bool {
+    return got.Type == want.Type &&
+           got.Display == want.Display &&
+           (want.Selector == "" || got.Selector == want.Selector) &&
+           (want.Host == "" || got.Host == want.Host) &&
+           (want.Port == "" || got.Port == want.Port)
+}
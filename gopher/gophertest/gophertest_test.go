@@ -0,0 +1,34 @@
+package gophertest
+
+import (
+    "testing"
+    "github.com/EaterLabs/gophor/gopher"
+)
+
+func TestServer(t *testing.T) {
+    s := NewServer(t, nil)
+    s.WriteFile("gophermap", "Welcome\n0Notes\tnotes.txt\n")
+    s.WriteFile("notes.txt", "Some notes\n")
+
+    s.AssertMenu("/", "iWelcome\n0Notes\t/notes.txt\n")
+    s.AssertText("/notes.txt", "Some notes\n")
+    s.AssertError("/missing.txt", gopher.ErrorResponse404)
+}
+
+/* Listings within gophermaps read directory configs while the outer
+ * gophermap holds the file cache, which must not deadlock
+ */
+func TestGophermapListingSmallCache(t *testing.T) {
+    options := gopher.DefaultServerOptions()
+    options.LogType   = 1
+    options.CacheSize = 2
+    s := NewServer(t, options)
+    s.WriteFile(".gophor", "title: Root\n")
+    s.WriteFile("sub/.gophor", "title: Sub\n")
+    s.WriteFile("sub/gophermap", "Hello\n*\n")
+    s.WriteFile("sub/notes.txt", "Some notes\n")
+
+    for i := 0; i < 3; i += 1 {
+        s.AssertMenu("/sub", "iHello\niSub\ni\n1..\n0gophermap\t/sub/gophermap\n0notes.txt\t/sub/notes.txt\n")
+    }
+}
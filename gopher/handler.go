@@ -0,0 +1,131 @@
+package gopher
+
+import (
+    "net"
+    "sort"
+    "sync"
+    "strings"
+)
+
+/* Request:
+ * A single gopher request as passed to a Handler. The selector
+ * has already been sanitized and had any rewrite rules applied,
+ * the query holds text following the selector (type 7 items).
+ */
+type Request struct {
+    Selector   string
+    Query      string
+    Host       *ConnHost
+    RemoteAddr net.Addr
+    Id         uint64
+
+    config     *ServerConfig
+}
+
+/* Log trace message tagged with request ID, if served by a Server */
+func (r *Request) Trace(format string, args ...interface{}) {
+    if r.config == nil {
+        return
+    }
+    r.config.LogTrace(r.Id, format, args...)
+}
+
+/* ResponseWriter:
+ * Used by a Handler to send its response. WriteError() sends the
+ * gopher error response for the error's code, and marks the request
+ * as failed in the access log.
+ */
+type ResponseWriter interface {
+    Write(b []byte) (int, error)
+    WriteError(gophorErr *GophorError)
+}
+
+/* Handler:
+ * Responds to gopher requests, in the style of net/http. A
+ * FileSystem is a Handler, as is a ServeMux routing between
+ * several of them.
+ */
+type Handler interface {
+    Serve(w ResponseWriter, r *Request)
+}
+
+/* Adapter allowing ordinary functions to be used as a Handler */
+type HandlerFunc func(ResponseWriter, *Request)
+
+func (f HandlerFunc) Serve(w ResponseWriter, r *Request) {
+    f(w, r)
+}
+
+/* ServeMux:
+ * Routes requests to the Handler registered under the longest
+ * prefix matching the selector. Prefixes match at path boundaries,
+ * so '/docs' matches '/docs' and '/docs/index' but not '/docsets'.
+ * Selectors are passed through unchanged.
+ */
+type ServeMux struct {
+    entries []*muxEntry
+    mutex   sync.RWMutex
+}
+
+type muxEntry struct {
+    Prefix  string
+    Handler Handler
+}
+
+type byPrefixLength []*muxEntry
+func (e byPrefixLength) Len() int           { return len(e) }
+func (e byPrefixLength) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byPrefixLength) Less(i, j int) bool { return len(e[i].Prefix) > len(e[j].Prefix) }
+
+func NewServeMux() *ServeMux {
+    return &ServeMux{ make([]*muxEntry, 0), sync.RWMutex{} }
+}
+
+/* Register handler for selectors under prefix, replacing any existing */
+func (mux *ServeMux) Handle(prefix string, handler Handler) {
+    prefix = sanitizePath(prefix)
+
+    mux.mutex.Lock()
+    defer mux.mutex.Unlock()
+
+    for _, entry := range mux.entries {
+        if entry.Prefix == prefix {
+            entry.Handler = handler
+            return
+        }
+    }
+    mux.entries = append(mux.entries, &muxEntry{ prefix, handler })
+    sort.Stable(byPrefixLength(mux.entries))
+}
+
+func (mux *ServeMux) HandleFunc(prefix string, handler func(ResponseWriter, *Request)) {
+    mux.Handle(prefix, HandlerFunc(handler))
+}
+
+/* Get handler registered for selector, nil if none */
+func (mux *ServeMux) Handler(selector string) Handler {
+    mux.mutex.RLock()
+    defer mux.mutex.RUnlock()
+
+    for _, entry := range mux.entries {
+        if matchSelectorPrefix(selector, entry.Prefix) {
+            return entry.Handler
+        }
+    }
+    return nil
+}
+
+func (mux *ServeMux) Serve(w ResponseWriter, r *Request) {
+    handler := mux.Handler(r.Selector)
+    if handler == nil {
+        r.Trace("No handler for selector: %s\n", r.Selector)
+        w.WriteError(&GophorError{ FileStatErr, nil })
+        return
+    }
+    handler.Serve(w, r)
+}
+
+/* Check if selector falls under prefix, at a path boundary */
+func matchSelectorPrefix(selector, prefix string) bool {
+    return prefix == "/" || selector == prefix || strings.HasPrefix(selector, prefix+"/")
+}
@@ -1,4 +1,4 @@
-package main
+package gopher
 
 func generateHtmlRedirect(url string) []byte {
     content :=
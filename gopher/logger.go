@@ -1,13 +1,43 @@
-package main
+package gopher
 
 import (
+    "fmt"
     "log"
     "os"
     "io"
     "io/ioutil"
+    "strings"
 )
 
-func setupLogging(loggingType int, systemLogPath, accessLogPath string) (*log.Logger, *log.Logger) {
+/* LogLevel:
+ * Threshold below which log messages are dropped. Ordered
+ * so that a simple comparison tells us whether to output.
+ */
+type LogLevel int
+const (
+    LogLevelDebug LogLevel = iota
+    LogLevelInfo  LogLevel = iota
+    LogLevelWarn  LogLevel = iota
+    LogLevelError LogLevel = iota
+)
+
+/* Parse user supplied log level string */
+func parseLogLevel(level string) (LogLevel, bool) {
+    switch strings.ToLower(level) {
+        case "debug":
+            return LogLevelDebug, true
+        case "info":
+            return LogLevelInfo, true
+        case "warn", "warning":
+            return LogLevelWarn, true
+        case "error":
+            return LogLevelError, true
+        default:
+            return LogLevelInfo, false
+    }
+}
+
+func setupLogging(loggingType int, systemLogPath, accessLogPath string) (*log.Logger, *log.Logger, *GophorError) {
     /* Setup global logger */
     log.SetOutput(os.Stderr)
     log.SetFlags(0)
@@ -26,7 +56,7 @@ func setupLogging(loggingType int, systemLogPath, accessLogPath string) (*log.Lo
             if systemLogPath != "" {
                 fd, err := os.OpenFile(systemLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
                 if err != nil {
-                    log.Fatalf("Failed to create system logger: %s\n", err.Error())
+                    return nil, nil, &GophorError{ FileOpenErr, err }
                 }
                 systemWriter = fd        
             } else {
@@ -44,7 +74,7 @@ func setupLogging(loggingType int, systemLogPath, accessLogPath string) (*log.Lo
             if accessLogPath != "" {
                 fd, err := os.OpenFile(accessLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
                 if err != nil {
-                    log.Fatalf("Failed to create access logger: %s\n", err.Error())
+                    return nil, nil, &GophorError{ FileOpenErr, err }
                 }
                 accessWriter = fd
             } else {
@@ -58,15 +88,8 @@ func setupLogging(loggingType int, systemLogPath, accessLogPath string) (*log.Lo
             accessLogger = systemLogger
 
         default:
-            log.Fatalf("Unrecognized logging type: %d\n", loggingType)
+            return nil, nil, &GophorError{ ConfigParseErr, fmt.Errorf("unrecognized logging type: %d", loggingType) }
     }
 
-    return systemLogger, accessLogger
-}
-
-func printVersionExit() {
-    /* Reset the flags before printing version */
-    log.SetFlags(0)
-    log.Printf("%s\n", GophorVersion)
-    os.Exit(0)
+    return systemLogger, accessLogger, nil
 }
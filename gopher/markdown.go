@@ -0,0 +1,235 @@
+package gopher
+
+import (
+    "bufio"
+    "regexp"
+    "strings"
+    "net/url"
+)
+
+/* Matches inline links and images: [text](target) / ![alt](target) */
+var markdownLinkRegex = regexp.MustCompile(`(!?)\[([^\]]*)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+
+/* Matches ordered and unordered list item markers */
+var markdownListRegex = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+`)
+
+/* MarkdownContents:
+ * Implementation of FileContents that renders a Markdown
+ * file into a gophermap at load time. Headings and paragraphs
+ * become wrapped info lines, links become menu items and code
+ * blocks are kept verbatim.
+ */
+type MarkdownContents struct {
+    path     string
+    contents []byte
+    config   *ServerConfig
+}
+
+func (mc *MarkdownContents) Render(request *FileSystemRequest) []byte {
+    /* Replace host placeholders, then add footer (which contains last line) */
+    return append(replaceStrings(string(mc.contents), request.Host), mc.config.FooterText...)
+}
+
+func (mc *MarkdownContents) Load() *GophorError {
+    var gophorErr *GophorError
+    mc.contents, gophorErr = mc.config.readMarkdown(mc.path)
+    return gophorErr
+}
+
+func (mc *MarkdownContents) Clear() {
+    mc.contents = nil
+}
+
+/* Check if file at path should be rendered as Markdown */
+func isMarkdownFile(filePath string) bool {
+    lower := strings.ToLower(filePath)
+    return strings.HasSuffix(lower, ".md") || strings.HasSuffix(lower, ".markdown")
+}
+
+/* Read Markdown file at path, rendering to gophermap bytes */
+func (config *ServerConfig) readMarkdown(mdPath string) ([]byte, *GophorError) {
+    contents := make([]byte, 0)
+
+    /* Link to the original file first */
+    contents = append(contents, config.buildLine(TypeFile, "View Markdown source", config.UserDirs.Selector(mdPath)+MarkdownRawSuffix, ReplaceStrHostname, ReplaceStrPort)...)
+    contents = append(contents, config.buildInfoLine("")...)
+
+    /* Paragraph lines are gathered then flushed together */
+    paragraph := make([]string, 0)
+    inCode := false
+
+    flush := func() {
+        if len(paragraph) == 0 {
+            return
+        }
+        contents = append(contents, config.renderMarkdownText(strings.Join(paragraph, " "), "", mdPath)...)
+        paragraph = paragraph[:0]
+    }
+
+    gophorErr := config.bufferedScan(mdPath,
+        func(scanner *bufio.Scanner) bool {
+            line := scanner.Text()
+            trimmed := strings.TrimSpace(line)
+
+            /* Fenced code blocks are kept verbatim */
+            if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+                flush()
+                inCode = !inCode
+                return true
+            } else if inCode {
+                contents = append(contents, config.buildInfoLine(expandTabs(line))...)
+                return true
+            }
+
+            switch {
+                case trimmed == "":
+                    /* Blank line ends paragraph */
+                    flush()
+                    contents = append(contents, config.buildInfoLine("")...)
+
+                case markdownListRegex.MatchString(line):
+                    /* List item, wrapped with continuation lines indented past the marker */
+                    flush()
+                    marker := markdownListRegex.FindString(line)
+                    contents = append(contents, config.renderMarkdownText(strings.TrimSpace(line[len(marker):]), strings.TrimRight(marker, " ")+" ", mdPath)...)
+
+                case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+                    /* Indented code block, kept verbatim */
+                    if len(paragraph) > 0 {
+                        /* Actually a paragraph continuation */
+                        paragraph = append(paragraph, trimmed)
+                    } else {
+                        contents = append(contents, config.buildInfoLine(expandTabs(line))...)
+                    }
+
+                case strings.HasPrefix(trimmed, "#"):
+                    /* Heading, underline the top two levels */
+                    flush()
+                    level := len(trimmed)-len(strings.TrimLeft(trimmed, "#"))
+                    heading := strings.TrimSpace(strings.Trim(trimmed, "#"))
+                    contents = append(contents, config.renderMarkdownText(heading, "", mdPath)...)
+                    switch level {
+                        case 1:
+                            contents = append(contents, config.buildInfoLine(strings.Repeat("=", minInt(stringWidth(heading), config.PageWidth)))...)
+                        case 2:
+                            contents = append(contents, config.buildInfoLine(strings.Repeat("-", minInt(stringWidth(heading), config.PageWidth)))...)
+                    }
+
+                case len(paragraph) > 0 && len(trimmed) >= 3 && (strings.Trim(trimmed, "=") == "" || strings.Trim(trimmed, "-") == ""):
+                    /* Setext-style heading underline, gathered paragraph is the heading */
+                    heading := strings.Join(paragraph, " ")
+                    paragraph = paragraph[:0]
+                    contents = append(contents, config.renderMarkdownText(heading, "", mdPath)...)
+                    contents = append(contents, config.buildInfoLine(strings.Repeat(trimmed[:1], minInt(stringWidth(heading), config.PageWidth)))...)
+
+                case isMarkdownRule(trimmed):
+                    flush()
+                    contents = append(contents, config.buildInfoLine(buildLineSeparator(config.PageWidth))...)
+
+                case strings.HasPrefix(trimmed, ">"):
+                    /* Blockquote, keep the marker on each line */
+                    flush()
+                    contents = append(contents, config.renderMarkdownText(strings.TrimSpace(strings.TrimPrefix(trimmed, ">")), "> ", mdPath)...)
+
+                default:
+                    paragraph = append(paragraph, trimmed)
+            }
+
+            return true
+        },
+    )
+
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+    flush()
+
+    return contents, nil
+}
+
+/* Render a block of Markdown text as wrapped info lines with the first
+ * line prefixed (continuation lines indented to match), followed by a
+ * menu item for each link found in the text.
+ */
+func (config *ServerConfig) renderMarkdownText(text, prefix, mdPath string) []byte {
+    contents := make([]byte, 0)
+
+    /* Pull out links, leaving their text in place */
+    links := markdownLinkRegex.FindAllStringSubmatch(text, -1)
+    text = markdownLinkRegex.ReplaceAllString(text, "$2")
+
+    /* Strip inline emphasis + code markers */
+    text = strings.NewReplacer("**", "", "__", "", "`", "").Replace(text)
+
+    if text != "" {
+        indent := strings.Repeat(" ", stringWidth(prefix))
+        for i, line := range wrapLine(text, config.PageWidth-stringWidth(prefix)) {
+            if i == 0 {
+                contents = append(contents, config.buildInfoLine(prefix+line)...)
+            } else {
+                contents = append(contents, config.buildInfoLine(indent+line)...)
+            }
+        }
+    }
+
+    for _, link := range links {
+        contents = append(contents, []byte(config.markdownLinkLine(link[1] == "!", link[2], link[3], mdPath)+DOSLineEnd)...)
+    }
+
+    return contents
+}
+
+/* Convert Markdown link to gophermap menu line */
+func (config *ServerConfig) markdownLinkLine(isImage bool, text, target, mdPath string) string {
+    if text == "" {
+        text = target
+    }
+
+    parsed, err := url.Parse(target)
+    switch {
+        case err == nil && parsed.Scheme == "gopher":
+            /* Typed gopher line from URL: gopher://host[:port]/<type><selector> */
+            itemType, selector := TypeDirectory, ""
+            if len(parsed.Path) > 1 {
+                itemType, selector = ItemType(parsed.Path[1]), parsed.Path[2:]
+            }
+            port := parsed.Port()
+            if port == "" {
+                port = DefaultGopherPort
+            }
+            return string(itemType)+text+Tab+selector+Tab+parsed.Hostname()+Tab+port
+
+        case err == nil && parsed.Scheme != "":
+            /* Anything else external (http, https, mailto...) becomes a URL: selector */
+            return config.completeGophermapLine(string(TypeHtml)+text+Tab+"URL:"+target, mdPath)
+
+        default:
+            /* Local link, guess the type and let completion resolve the selector */
+            itemType := TypeDirectory
+            if mappedType, ok := config.TypeMap.Lookup(target); ok && !strings.HasSuffix(target, "/") {
+                itemType = mappedType
+            } else if isImage {
+                itemType = TypeImage
+            }
+            if config.RenderMarkdown && isMarkdownFile(target) {
+                itemType = TypeDirectory
+            }
+            return config.completeGophermapLine(string(itemType)+text+Tab+target, mdPath)
+    }
+}
+
+/* Check for horizontal rule: 3+ of '-', '*' or '_' (optionally spaced) */
+func isMarkdownRule(line string) bool {
+    line = strings.Replace(line, " ", "", -1)
+    if len(line) < 3 {
+        return false
+    }
+    return strings.Trim(line, "-") == "" || strings.Trim(line, "*") == "" || strings.Trim(line, "_") == ""
+}
+
+func minInt(a, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
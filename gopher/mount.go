@@ -0,0 +1,434 @@
+package gopher
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "path"
+    "sort"
+    "sync"
+    "time"
+    "bufio"
+    "errors"
+    "io/fs"
+    "strings"
+)
+
+/* MountFS:
+ * An fs.FS made up of other filesystems mounted at selector
+ * prefixes, e.g. the site content at '/', a shared archive volume
+ * at '/pub' and generated output at '/docs'. Lookups go to the
+ * longest matching mount, and directories above a mount point
+ * list it alongside their own entries (created if necessary).
+ */
+type MountFS struct {
+    Mounts []*Mount
+    Mutex  sync.RWMutex
+}
+
+/* Mount:
+ * A filesystem mounted at a path, held in fs.FS form (so
+ * without leading '/', and "." for the root)
+ */
+type Mount struct {
+    Path string
+    FS   fs.FS
+}
+
+type byMountDepth []*Mount
+func (m byMountDepth) Len() int           { return len(m) }
+func (m byMountDepth) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byMountDepth) Less(i, j int) bool { return len(m[i].Path) > len(m[j].Path) }
+
+func NewMountFS() *MountFS {
+    return &MountFS{ make([]*Mount, 0), sync.RWMutex{} }
+}
+
+/* Mount filesystem at selector prefix, replacing any already mounted there */
+func (m *MountFS) Mount(prefix string, fsys fs.FS) {
+    mountPath := sourcePath(prefix)
+
+    m.Mutex.Lock()
+    defer m.Mutex.Unlock()
+
+    for _, mount := range m.Mounts {
+        if mount.Path == mountPath {
+            mount.FS = fsys
+            return
+        }
+    }
+    m.Mounts = append(m.Mounts, &Mount{ mountPath, fsys })
+    sort.Stable(byMountDepth(m.Mounts))
+}
+
+/* Get filesystem and path within it for name, from the longest matching mount */
+func (m *MountFS) resolve(name string) (fs.FS, string, bool) {
+    m.Mutex.RLock()
+    defer m.Mutex.RUnlock()
+
+    for _, mount := range m.Mounts {
+        switch {
+            case mount.Path == name:
+                return mount.FS, ".", true
+            case mount.Path == ".":
+                return mount.FS, name, true
+            case strings.HasPrefix(name, mount.Path+"/"):
+                return mount.FS, strings.TrimPrefix(name, mount.Path+"/"), true
+        }
+    }
+    return nil, "", false
+}
+
+/* Get names of entries directly below directory name leading to (or being) mount points */
+func (m *MountFS) childMounts(name string) []string {
+    m.Mutex.RLock()
+    defer m.Mutex.RUnlock()
+
+    children := make([]string, 0)
+    for _, mount := range m.Mounts {
+        rest := ""
+        switch {
+            case mount.Path == ".":
+                continue
+            case name == ".":
+                rest = mount.Path
+            case strings.HasPrefix(mount.Path, name+"/"):
+                rest = strings.TrimPrefix(mount.Path, name+"/")
+            default:
+                continue
+        }
+
+        child := strings.SplitN(rest, "/", 2)[0]
+        if !hasString(children, child) {
+            children = append(children, child)
+        }
+    }
+    return children
+}
+
+/* Check if name is a directory leading to a mount point */
+func (m *MountFS) isMountParent(name string) bool {
+    m.Mutex.RLock()
+    defer m.Mutex.RUnlock()
+
+    for _, mount := range m.Mounts {
+        if mount.Path != "." && (name == "." || strings.HasPrefix(mount.Path, name+"/")) {
+            return true
+        }
+    }
+    return false
+}
+
+func (m *MountFS) Open(name string) (fs.File, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{ Op: "open", Path: name, Err: fs.ErrInvalid }
+    }
+
+    /* Directories leading to mount points need their entries merged,
+     * and mount points are reported under their name within the parent
+     */
+    fsys, rel, ok := m.resolve(name)
+    if m.isMountParent(name) || (ok && rel == "." && name != ".") {
+        info, err := m.Stat(name)
+        if err != nil {
+            return nil, err
+        } else if !info.IsDir() {
+            return fsys.Open(rel)
+        }
+        entries, err := m.ReadDir(name)
+        if err != nil {
+            return nil, err
+        }
+        return &dirFile{ info, entries, 0 }, nil
+    }
+
+    if !ok {
+        return nil, &fs.PathError{ Op: "open", Path: name, Err: fs.ErrNotExist }
+    }
+    return fsys.Open(rel)
+}
+
+func (m *MountFS) Stat(name string) (fs.FileInfo, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{ Op: "stat", Path: name, Err: fs.ErrInvalid }
+    }
+
+    fsys, rel, ok := m.resolve(name)
+    if ok {
+        info, err := fs.Stat(fsys, rel)
+        if err == nil {
+            return &renamedFileInfo{ info, path.Base(name) }, nil
+        } else if !errors.Is(err, fs.ErrNotExist) || !m.isMountParent(name) {
+            return nil, err
+        }
+    } else if !m.isMountParent(name) {
+        return nil, &fs.PathError{ Op: "stat", Path: name, Err: fs.ErrNotExist }
+    }
+
+    /* Directory only existing to lead to a mount point */
+    return &mountDirInfo{ path.Base(name) }, nil
+}
+
+func (m *MountFS) ReadDir(name string) ([]fs.DirEntry, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{ Op: "readdir", Path: name, Err: fs.ErrInvalid }
+    }
+
+    entries := make([]fs.DirEntry, 0)
+    fsys, rel, ok := m.resolve(name)
+    if ok {
+        var err error
+        entries, err = fs.ReadDir(fsys, rel)
+        if err != nil && (!errors.Is(err, fs.ErrNotExist) || !m.isMountParent(name)) {
+            return nil, err
+        }
+    } else if !m.isMountParent(name) {
+        return nil, &fs.PathError{ Op: "readdir", Path: name, Err: fs.ErrNotExist }
+    }
+
+    /* Mount points take the place of any entries they shadow */
+    for _, child := range m.childMounts(name) {
+        info, err := m.Stat(path.Join(name, child))
+        if err != nil {
+            continue
+        }
+        entries = setDirEntry(entries, fs.FileInfoToDirEntry(info))
+    }
+    sort.Sort(byEntryName(entries))
+    return entries, nil
+}
+
+/* UnionFS:
+ * An fs.FS merging several layers, in priority order. Lookups
+ * are answered by the first layer containing the name, and
+ * directory listings merge the entries of every layer (the first
+ * layer's entry winning where names clash).
+ */
+type UnionFS struct {
+    Layers []fs.FS
+}
+
+func NewUnionFS(layers ...fs.FS) *UnionFS {
+    return &UnionFS{ layers }
+}
+
+func (u *UnionFS) Open(name string) (fs.File, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{ Op: "open", Path: name, Err: fs.ErrInvalid }
+    }
+
+    for _, layer := range u.Layers {
+        file, err := layer.Open(name)
+        if errors.Is(err, fs.ErrNotExist) {
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+
+        info, err := file.Stat()
+        if err != nil || !info.IsDir() {
+            return file, err
+        }
+        file.Close()
+
+        /* Directory, merge with those in the layers below */
+        entries, err := u.ReadDir(name)
+        if err != nil {
+            return nil, err
+        }
+        return &dirFile{ info, entries, 0 }, nil
+    }
+    return nil, &fs.PathError{ Op: "open", Path: name, Err: fs.ErrNotExist }
+}
+
+func (u *UnionFS) Stat(name string) (fs.FileInfo, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{ Op: "stat", Path: name, Err: fs.ErrInvalid }
+    }
+
+    for _, layer := range u.Layers {
+        info, err := fs.Stat(layer, name)
+        if errors.Is(err, fs.ErrNotExist) {
+            continue
+        }
+        return info, err
+    }
+    return nil, &fs.PathError{ Op: "stat", Path: name, Err: fs.ErrNotExist }
+}
+
+func (u *UnionFS) ReadDir(name string) ([]fs.DirEntry, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{ Op: "readdir", Path: name, Err: fs.ErrInvalid }
+    }
+
+    var entries []fs.DirEntry
+    for _, layer := range u.Layers {
+        layerEntries, err := fs.ReadDir(layer, name)
+        if err != nil {
+            /* Not a directory in this layer, skip it */
+            continue
+        }
+
+        if entries == nil {
+            entries = layerEntries
+            continue
+        }
+        for _, entry := range layerEntries {
+            if !hasDirEntry(entries, entry.Name()) {
+                entries = append(entries, entry)
+            }
+        }
+    }
+
+    if entries == nil {
+        return nil, &fs.PathError{ Op: "readdir", Path: name, Err: fs.ErrNotExist }
+    }
+    sort.Sort(byEntryName(entries))
+    return entries, nil
+}
+
+/* dirFile:
+ * An open directory with pre-read (merged) entries, returned
+ * by MountFS and UnionFS in place of the underlying directory
+ */
+type dirFile struct {
+    info    fs.FileInfo
+    entries []fs.DirEntry
+    offset  int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+func (d *dirFile) Read(b []byte) (int, error) {
+    return 0, &fs.PathError{ Op: "read", Path: d.info.Name(), Err: errors.New("is a directory") }
+}
+
+func (d *dirFile) ReadDir(count int) ([]fs.DirEntry, error) {
+    remaining := d.entries[d.offset:]
+    if count <= 0 {
+        d.offset = len(d.entries)
+        return remaining, nil
+    } else if len(remaining) == 0 {
+        return nil, io.EOF
+    }
+
+    if count > len(remaining) {
+        count = len(remaining)
+    }
+    d.offset += count
+    return remaining[:count], nil
+}
+
+/* renamedFileInfo:
+ * File info reported under a different name, e.g. the root
+ * of a filesystem seen from the directory it is mounted in
+ */
+type renamedFileInfo struct {
+    fs.FileInfo
+    name string
+}
+
+func (r *renamedFileInfo) Name() string { return r.name }
+
+/* mountDirInfo:
+ * File info of a directory that only exists to lead to a mount point
+ */
+type mountDirInfo struct {
+    name string
+}
+
+func (m *mountDirInfo) Name() string       { return m.name }
+func (m *mountDirInfo) Size() int64        { return 0 }
+func (m *mountDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (m *mountDirInfo) ModTime() time.Time { return time.Time{} }
+func (m *mountDirInfo) IsDir() bool        { return true }
+func (m *mountDirInfo) Sys() interface{}   { return nil }
+
+type byEntryName []fs.DirEntry
+func (e byEntryName) Len() int           { return len(e) }
+func (e byEntryName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byEntryName) Less(i, j int) bool { return e[i].Name() < e[j].Name() }
+
+func hasString(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
+
+func hasDirEntry(entries []fs.DirEntry, name string) bool {
+    for _, entry := range entries {
+        if entry.Name() == name {
+            return true
+        }
+    }
+    return false
+}
+
+/* Replace entry of same name in entries, else append */
+func setDirEntry(entries []fs.DirEntry, entry fs.DirEntry) []fs.DirEntry {
+    for i := range entries {
+        if entries[i].Name() == entry.Name() {
+            entries[i] = entry
+            return entries
+        }
+    }
+    return append(entries, entry)
+}
+
+/* Load mount table file, mounting its directories over base. Each line
+ * holds a selector prefix followed by one or more directories, several
+ * directories forming a union (first taking priority). Directories are
+ * opened now, so they remain accessible from within a chroot.
+ */
+func loadMountTable(tablePath string, base fs.FS) (*MountFS, *GophorError) {
+    mounts := NewMountFS()
+    mounts.Mount("/", base)
+
+    lineNo := 0
+    var parseErr *GophorError
+    gophorErr := bufferedScanFile(tablePath,
+        func(scanner *bufio.Scanner) bool {
+            lineNo += 1
+            line := strings.TrimSpace(scanner.Text())
+
+            /* Skip empty lines and comments */
+            if line == "" || strings.HasPrefix(line, "#") {
+                return true
+            }
+
+            /* Line format: <selector prefix> <directory> [directory ...] */
+            fields := strings.Fields(line)
+            if len(fields) < 2 || !strings.HasPrefix(fields[0], "/") {
+                parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid line %d", tablePath, lineNo) }
+                return false
+            }
+
+            layers := make([]fs.FS, 0)
+            for _, dir := range fields[1:] {
+                root, err := os.OpenRoot(dir)
+                if err != nil {
+                    parseErr = &GophorError{ FileOpenErr, fmt.Errorf("%s: line %d: %s", tablePath, lineNo, err.Error()) }
+                    return false
+                }
+                layers = append(layers, root.FS())
+            }
+
+            if len(layers) == 1 {
+                mounts.Mount(fields[0], layers[0])
+            } else {
+                mounts.Mount(fields[0], NewUnionFS(layers...))
+            }
+            return true
+        },
+    )
+
+    if gophorErr != nil {
+        return nil, gophorErr
+    } else if parseErr != nil {
+        return nil, parseErr
+    }
+    return mounts, nil
+}
@@ -0,0 +1,408 @@
+package gopher
+
+import (
+    "os"
+    "fmt"
+    "path"
+    "sort"
+    "time"
+    "bytes"
+    "bufio"
+    "regexp"
+    "strings"
+    "strconv"
+)
+
+/* Matches dates prefixing post filenames, e.g. '2020-01-31-post.txt' or '20200131_post.txt' */
+var phlogDateRegex = regexp.MustCompile(`^(\d{4})-?(\d{2})-?(\d{2})`)
+
+/* PhlogPost:
+ * Details of a single post within a phlog directory,
+ * collected when the phlog is loaded.
+ */
+type PhlogPost struct {
+    Path  string
+    Name  string
+    Title string
+    Date  time.Time
+    Type  ItemType
+}
+
+/* PhlogContents:
+ * Implementation of FileContents that collects the posts
+ * within a phlog directory, sorted newest-first. Cached under
+ * the directory's own path, so posts being added or removed
+ * (changing the directory modification time) or edited (tracked
+ * as dependencies) mark it unfresh. Render() is unused, index
+ * pages and post navigation are generated from the posts directly.
+ */
+type PhlogContents struct {
+    path   string
+    posts  []*PhlogPost
+    deps   map[string]bool
+    config *ServerConfig
+}
+
+func (pc *PhlogContents) Render(request *FileSystemRequest) []byte {
+    /* Never served */
+    return nil
+}
+
+func (pc *PhlogContents) Load() *GophorError {
+    var gophorErr *GophorError
+    pc.posts, pc.deps, gophorErr = pc.config.readPhlog(pc.path)
+    return gophorErr
+}
+
+func (pc *PhlogContents) Clear() {
+    pc.posts = nil
+    pc.deps  = nil
+}
+
+func (pc *PhlogContents) Dependencies() map[string]bool {
+    return pc.deps
+}
+
+/* Get posts not hidden by directory config, newest-first */
+func (pc *PhlogContents) visiblePosts(dirConfig *DirConfig) []*PhlogPost {
+    posts := make([]*PhlogPost, 0)
+    for _, post := range pc.posts {
+        if !dirConfig.IsHidden(post.Name) {
+            posts = append(posts, post)
+        }
+    }
+    return posts
+}
+
+/* Render index page listing posts newest-first. If year is non-zero,
+ * renders the archive page of all posts from that year instead. The
+ * intro (if any) replaces the title at the top of the first page.
+ */
+func (pc *PhlogContents) RenderIndex(request *FileSystemRequest, dirConfig *DirConfig, page, year int, intro []byte) ([]byte, *GophorError) {
+    selector := pc.config.UserDirs.Selector(request.Path)
+    posts := pc.visiblePosts(dirConfig)
+
+    /* Get page size, falling back to default */
+    pageSize := dirConfig.PhlogPageSize
+    if pageSize < 1 {
+        pageSize = PhlogPageSize
+    }
+    pageCount := (len(posts)+pageSize-1) / pageSize
+    if pageCount < 1 {
+        pageCount = 1
+    }
+
+    /* Collect post counts per year for the archive section */
+    years := make([]int, 0)
+    yearCounts := make(map[int]int)
+    for _, post := range posts {
+        if _, ok := yearCounts[post.Date.Year()]; !ok {
+            years = append(years, post.Date.Year())
+        }
+        yearCounts[post.Date.Year()] += 1
+    }
+
+    /* Pick out posts to be shown on this page */
+    var shown []*PhlogPost
+    if year != 0 {
+        if yearCounts[year] == 0 {
+            return nil, &GophorError{ FileStatErr, nil }
+        }
+        for _, post := range posts {
+            if post.Date.Year() == year {
+                shown = append(shown, post)
+            }
+        }
+    } else {
+        if page < 1 || page > pageCount {
+            return nil, &GophorError{ FileStatErr, nil }
+        }
+        shown = posts[(page-1)*pageSize:minInt(page*pageSize, len(posts))]
+    }
+
+    /* Add intro, else a title + a space */
+    contents := make([]byte, 0)
+    if intro != nil && page == 1 && year == 0 {
+        contents = append(contents, intro...)
+    } else {
+        title := "[ "+request.Host.Name+selector+" ]"
+        if dirConfig.Title != "" {
+            title = dirConfig.Title
+        }
+        contents = append(contents, pc.config.buildLine(TypeInfo, title, "TITLE", NullHost, NullPort)...)
+        contents = append(contents, pc.config.buildInfoLine("")...)
+    }
+
+    /* Add feed link if enabled */
+    if dirConfig.Feed {
+        contents = append(contents, pc.config.buildFeedLine(selector, request.Host)...)
+        contents = append(contents, pc.config.buildInfoLine("")...)
+    }
+
+    if year != 0 {
+        contents = append(contents, pc.config.buildInfoLine("Posts from "+strconv.Itoa(year)+":")...)
+        contents = append(contents, pc.config.buildInfoLine("")...)
+    }
+
+    /* Add post entries */
+    for _, post := range shown {
+        contents = append(contents, pc.config.buildLine(phlogPostType(post, dirConfig), post.Date.Format(ListDateFormat)+"  "+post.Title, pc.config.UserDirs.Selector(post.Path), request.Host.Name, request.Host.Port)...)
+    }
+    contents = append(contents, pc.config.buildInfoLine("")...)
+
+    /* Archive pages just link back to the index */
+    if year != 0 {
+        contents = append(contents, pc.config.buildLine(TypeDirectory, "Back to index", selector, request.Host.Name, request.Host.Port)...)
+        return contents, nil
+    }
+
+    /* Add page navigation */
+    if pageCount > 1 {
+        contents = append(contents, pc.config.buildInfoLine(fmt.Sprintf("Page %d of %d", page, pageCount))...)
+        if page == 2 {
+            contents = append(contents, pc.config.buildLine(TypeDirectory, "Newer posts", selector, request.Host.Name, request.Host.Port)...)
+        } else if page > 2 {
+            contents = append(contents, pc.config.buildLine(TypeDirectory, "Newer posts", selector+PhlogPageQuery+strconv.Itoa(page-1), request.Host.Name, request.Host.Port)...)
+        }
+        if page < pageCount {
+            contents = append(contents, pc.config.buildLine(TypeDirectory, "Older posts", selector+PhlogPageQuery+strconv.Itoa(page+1), request.Host.Name, request.Host.Port)...)
+        }
+        contents = append(contents, pc.config.buildInfoLine("")...)
+    }
+
+    /* Add per-year archives */
+    if len(years) > 0 {
+        contents = append(contents, pc.config.buildInfoLine("Archives:")...)
+        for _, y := range years {
+            plural := "s"
+            if yearCounts[y] == 1 {
+                plural = ""
+            }
+            contents = append(contents, pc.config.buildLine(TypeDirectory, fmt.Sprintf("%d (%d post%s)", y, yearCounts[y], plural), selector+PhlogYearQuery+strconv.Itoa(y), request.Host.Name, request.Host.Port)...)
+        }
+    }
+
+    return contents, nil
+}
+
+/* Render previous / next navigation for post at postPath, as menu lines
+ * if the post is served as a menu, else as plain text with gopher URLs.
+ */
+func (pc *PhlogContents) RenderNavigation(request *FileSystemRequest, dirConfig *DirConfig, postPath string, asMenu bool) []byte {
+    posts := pc.visiblePosts(dirConfig)
+
+    /* Find the post, newer posts come first */
+    index := -1
+    for i, post := range posts {
+        if post.Path == postPath {
+            index = i
+            break
+        }
+    }
+    if index < 0 {
+        return nil
+    }
+
+    type navLink struct {
+        label string
+        post  *PhlogPost
+    }
+    links := make([]navLink, 0)
+    if index+1 < len(posts) {
+        links = append(links, navLink{ "Previous", posts[index+1] })
+    }
+    if index > 0 {
+        links = append(links, navLink{ "Next", posts[index-1] })
+    }
+
+    indexSelector := pc.config.UserDirs.Selector(path.Dir(postPath))
+    contents := make([]byte, 0)
+    if asMenu {
+        contents = append(contents, pc.config.buildInfoLine("")...)
+        for _, link := range links {
+            contents = append(contents, pc.config.buildLine(phlogPostType(link.post, dirConfig), link.label+": "+link.post.Title, pc.config.UserDirs.Selector(link.post.Path), request.Host.Name, request.Host.Port)...)
+        }
+        contents = append(contents, pc.config.buildLine(TypeDirectory, "Back to index", indexSelector, request.Host.Name, request.Host.Port)...)
+    } else {
+        contents = append(contents, []byte(DOSLineEnd+buildLineSeparator(pc.config.PageWidth)+DOSLineEnd)...)
+        for _, link := range links {
+            contents = append(contents, []byte(link.label+": "+link.post.Title+DOSLineEnd)...)
+            contents = append(contents, []byte("  "+buildGopherUrl(phlogPostType(link.post, dirConfig), pc.config.UserDirs.Selector(link.post.Path), request.Host.Name, request.Host.Port)+DOSLineEnd)...)
+        }
+        contents = append(contents, []byte("Index: "+buildGopherUrl(TypeDirectory, indexSelector, request.Host.Name, request.Host.Port)+DOSLineEnd)...)
+    }
+
+    return contents
+}
+
+/* Get item type for post, directory config types take priority */
+func phlogPostType(post *PhlogPost, dirConfig *DirConfig) ItemType {
+    if itemType, ok := dirConfig.ItemType(post.Name); ok {
+        return itemType
+    }
+    return post.Type
+}
+
+/* Fetch cached phlog for directory at request path, calling use with it read-locked */
+func (fs *FileSystem) fetchPhlog(request *FileSystemRequest, use func(*PhlogContents)) *GophorError {
+    return fs.fetchCached(request,
+        func(path string) FileContents {
+            return &PhlogContents{ path, nil, nil, fs.config }
+        },
+        func(file *File) {
+            use(file.contents.(*PhlogContents))
+        },
+    )
+}
+
+/* Get phlog index page for directory at request path */
+func (fs *FileSystem) FetchPhlogIndex(request *FileSystemRequest, dirConfig *DirConfig, page, year int, intro []byte) ([]byte, *GophorError) {
+    var output []byte
+    var renderErr *GophorError
+    gophorErr := fs.fetchPhlog(request, func(phlog *PhlogContents) {
+        output, renderErr = phlog.RenderIndex(request, dirConfig, page, year, intro)
+    })
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+    return output, renderErr
+}
+
+/* Add previous / next navigation to the rendered output of post at request
+ * path. Only text posts and those rendered as menus get navigation, anything
+ * else (e.g. images, archives) is returned unchanged.
+ */
+func (fs *FileSystem) addPhlogNavigation(request *FileSystemRequest, dirConfig *DirConfig, output []byte) []byte {
+    var navigation []byte
+    var asMenu bool
+    gophorErr := fs.fetchPhlog(&FileSystemRequest{ path.Dir(request.Path), "", request.Host, request.Id, request.config }, func(phlog *PhlogContents) {
+        postType := TypeDefault
+        for _, post := range phlog.posts {
+            if post.Path == request.Path {
+                postType = phlogPostType(post, dirConfig)
+                break
+            }
+        }
+        if postType != TypeFile && postType != TypeDirectory {
+            return
+        }
+
+        asMenu = postType == TypeDirectory
+        navigation = phlog.RenderNavigation(request, dirConfig, request.Path, asMenu)
+    })
+    if gophorErr != nil {
+        fs.config.LogSystemError("Failed to read phlog %s: %s\n", path.Dir(request.Path), gophorErr.Error())
+        return output
+    } else if navigation == nil {
+        return output
+    }
+
+    if asMenu {
+        /* Rendered menus end with the footer, navigation goes before it */
+        if bytes.HasSuffix(output, fs.config.FooterText) {
+            output = output[:len(output)-len(fs.config.FooterText)]
+        }
+        return append(append(append([]byte{}, output...), navigation...), fs.config.FooterText...)
+    }
+
+    /* Make sure navigation starts on a new line */
+    output = append([]byte{}, output...)
+    if len(output) > 0 && !bytes.HasSuffix(output, []byte("\n")) {
+        output = append(output, []byte(DOSLineEnd)...)
+    }
+    return append(output, navigation...)
+}
+
+/* Split a phlog index query from request path, returning the directory path and page or year */
+func parsePhlogQuery(requestPath string) (string, int, int, bool) {
+    for _, query := range []string{ PhlogPageQuery, PhlogYearQuery } {
+        i := strings.LastIndex(requestPath, query)
+        if i < 0 {
+            continue
+        }
+
+        value, err := strconv.Atoi(requestPath[i+len(query):])
+        if err != nil || value < 1 {
+            return requestPath, 0, 0, false
+        }
+
+        dirPath := requestPath[:i]
+        if dirPath == "" {
+            dirPath = "/"
+        }
+
+        if query == PhlogPageQuery {
+            return dirPath, value, 0, true
+        }
+        return dirPath, 0, value, true
+    }
+    return requestPath, 0, 0, false
+}
+
+/* Read posts from phlog directory at path, returning them newest-first
+ * along with the dependencies map of post paths.
+ */
+func (config *ServerConfig) readPhlog(dirPath string) ([]*PhlogPost, map[string]bool, *GophorError) {
+    /* Read files in directory */
+    files, err := config.readContentDir(dirPath)
+    if err != nil {
+        return nil, nil, &GophorError{ DirListErr, err }
+    }
+
+    posts := make([]*PhlogPost, 0)
+    deps := make(map[string]bool)
+    for _, file := range files {
+        /* Only regular files are posts, skipping gophermap (index intro), dotfiles + restricted */
+        name := file.Name()
+        if file.Mode() & os.ModeType != 0 || name == GophermapFileStr || strings.HasPrefix(name, ".") || config.isRestrictedFile(name) {
+            continue
+        }
+
+        postPath := path.Join(dirPath, name)
+        post := &PhlogPost{ postPath, name, name, file.ModTime(), config.FileSystem.GetItemType(postPath, file) }
+
+        /* Date from filename prefix if there, else modification time */
+        if match := phlogDateRegex.FindStringSubmatch(name); match != nil {
+            date, err := time.Parse("20060102", match[1]+match[2]+match[3])
+            if err == nil {
+                post.Date = date
+            }
+        }
+
+        /* Title from first line of text posts */
+        if post.Type == TypeFile || isMarkdownFile(name) {
+            if title := config.readPhlogTitle(postPath); title != "" {
+                post.Title = title
+            }
+        }
+
+        posts = append(posts, post)
+        deps[postPath] = true
+    }
+
+    sort.Sort(byPostDate(posts))
+    return posts, deps, nil
+}
+
+/* Get first non-empty line of post, stripping any Markdown heading markers */
+func (config *ServerConfig) readPhlogTitle(postPath string) string {
+    title := ""
+    config.bufferedScan(postPath,
+        func(scanner *bufio.Scanner) bool {
+            title = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(scanner.Text()), "#"))
+            return title == ""
+        },
+    )
+    return title
+}
+
+/* Sort posts newest-first, then by name */
+type byPostDate []*PhlogPost
+func (s byPostDate) Len() int           { return len(s) }
+func (s byPostDate) Less(i, j int) bool {
+    if !s[i].Date.Equal(s[j].Date) {
+        return s[i].Date.After(s[j].Date)
+    }
+    return s[i].Name > s[j].Name
+}
+func (s byPostDate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -1,12 +1,8 @@
-package main
+package gopher
 
-import (
-    "os"
-)
-
-func cachePolicyFiles(description, admin, geoloc string) {
+func (config *ServerConfig) cachePolicyFiles(description, admin, geoloc string) {
     /* See if caps txt exists, if not generate */
-    _, err := os.Stat("/caps.txt")
+    _, err := config.statContent("/caps.txt")
     if err != nil {
         /* We need to generate the caps txt and manually load into cache */
         content := generateCapsTxt(description, admin, geoloc)
@@ -19,13 +15,13 @@ func cachePolicyFiles(description, admin, geoloc string) {
         file.LoadContents()
 
         /* No need to worry about mutexes here, no other goroutines running yet */
-        Config.FileSystem.CacheMap.Put("/caps.txt", file)
+        config.FileSystem.CacheMap.Put("/caps.txt", file)
 
-        Config.LogSystem("Generated policy file: /caps.txt\n")
+        config.LogSystem("Generated policy file: /caps.txt\n")
     }
 
     /* See if caps txt exists, if not generate */
-    _, err = os.Stat("/robots.txt")
+    _, err = config.statContent("/robots.txt")
     if err != nil {
         /* We need to generate the caps txt and manually load into cache */
         content := generateRobotsTxt()
@@ -38,9 +34,9 @@ func cachePolicyFiles(description, admin, geoloc string) {
         file.LoadContents()
 
         /* No need to worry about mutexes here, no other goroutines running yet */
-        Config.FileSystem.CacheMap.Put("/robots.txt", file)
+        config.FileSystem.CacheMap.Put("/robots.txt", file)
 
-        Config.LogSystem("Generated policy file: /robots.txt\n")
+        config.LogSystem("Generated policy file: /robots.txt\n")
     }
 }
 
@@ -0,0 +1,190 @@
+package gopher
+
+import (
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+/* East Asian Wide and Fullwidth ranges (plus common emoji), each of
+ * which take up two columns when displayed in a terminal.
+ */
+var wideRuneRanges = [][2]rune{
+    { 0x1100,  0x115F  }, /* Hangul Jamo */
+    { 0x2E80,  0x303E  }, /* CJK Radicals .. CJK Symbols and Punctuation */
+    { 0x3041,  0x33FF  }, /* Hiragana .. CJK Compatibility */
+    { 0x3400,  0x4DBF  }, /* CJK Unified Ideographs Extension A */
+    { 0x4E00,  0x9FFF  }, /* CJK Unified Ideographs */
+    { 0xA000,  0xA4CF  }, /* Yi Syllables + Radicals */
+    { 0xAC00,  0xD7A3  }, /* Hangul Syllables */
+    { 0xF900,  0xFAFF  }, /* CJK Compatibility Ideographs */
+    { 0xFE30,  0xFE4F  }, /* CJK Compatibility Forms */
+    { 0xFF00,  0xFF60  }, /* Fullwidth Forms */
+    { 0xFFE0,  0xFFE6  }, /* Fullwidth Signs */
+    { 0x1F300, 0x1F64F }, /* Misc Symbols and Pictographs .. Emoticons */
+    { 0x1F900, 0x1F9FF }, /* Supplemental Symbols and Pictographs */
+    { 0x20000, 0x2FFFD }, /* CJK Unified Ideographs Extension B .. */
+    { 0x30000, 0x3FFFD }, /* CJK Unified Ideographs Extension G .. */
+}
+
+/* Get display width of rune in columns */
+func runeWidth(r rune) int {
+    /* Combining marks and control characters take up no space */
+    if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.IsControl(r) {
+        return 0
+    }
+
+    for _, wide := range wideRuneRanges {
+        if r < wide[0] {
+            break
+        } else if r <= wide[1] {
+            return 2
+        }
+    }
+
+    return 1
+}
+
+/* Get display width of string in columns */
+func stringWidth(str string) int {
+    width := 0
+    for _, r := range str {
+        width += runeWidth(r)
+    }
+    return width
+}
+
+/* Cut string down to fit within width columns, never splitting a rune */
+func truncateToWidth(str string, width int) string {
+    current := 0
+    for i, r := range str {
+        current += runeWidth(r)
+        if current > width {
+            return str[:i]
+        }
+    }
+    return str
+}
+
+/* Truncate name to fit width columns, marking truncation with '...' */
+func truncateName(name string, width int) string {
+    if stringWidth(name) <= width {
+        return name
+    } else if width <= 3 {
+        return truncateToWidth(name, width)
+    }
+    return truncateToWidth(name, width-3)+"..."
+}
+
+/* Pad string with spaces up to width columns */
+func padToWidth(str string, width int) string {
+    padding := width-stringWidth(str)
+    if padding <= 0 {
+        return str
+    }
+    return str+strings.Repeat(" ", padding)
+}
+
+/* Expand tabs to spaces, aligned to TabWidth column stops */
+func expandTabs(line string) string {
+    if !strings.Contains(line, Tab) {
+        return line
+    }
+
+    expanded := ""
+    column := 0
+    for _, r := range line {
+        if r == '\t' {
+            spaces := TabWidth-(column % TabWidth)
+            expanded += strings.Repeat(" ", spaces)
+            column += spaces
+        } else {
+            expanded += string(r)
+            column += runeWidth(r)
+        }
+    }
+    return expanded
+}
+
+/* Reflow line into lines no wider than width columns, wrapping at word
+ * boundaries. Continuation lines keep the original line's indentation,
+ * words too wide to fit on a line of their own are split.
+ */
+func wrapLine(line string, width int) []string {
+    /* Make sure we're working with valid UTF-8 and no tabs */
+    line = expandTabs(strings.ToValidUTF8(line, string(utf8.RuneError)))
+    if stringWidth(line) <= width {
+        return []string{ line }
+    }
+
+    /* Get indentation, dropped if it would leave too little room */
+    indent := line[:len(line)-len(strings.TrimLeft(line, " "))]
+    if stringWidth(indent) > width/2 {
+        indent = ""
+    }
+
+    lines := make([]string, 0)
+    current := indent
+    currentWidth := stringWidth(indent)
+    empty := true
+
+    /* Walk through words, keeping hold of the spacing before each */
+    rest := strings.TrimLeft(line, " ")
+    for rest != "" {
+        wordStart := strings.IndexFunc(rest, func(r rune) bool { return r != ' ' })
+        if wordStart < 0 {
+            break
+        }
+        gap := rest[:wordStart]
+        rest = rest[wordStart:]
+
+        wordEnd := strings.IndexRune(rest, ' ')
+        if wordEnd < 0 {
+            wordEnd = len(rest)
+        }
+        word := rest[:wordEnd]
+        rest = rest[wordEnd:]
+        wordWidth := stringWidth(word)
+
+        /* Start new line if word doesn't fit on this one, else keep original spacing */
+        if !empty && currentWidth+len(gap)+wordWidth > width {
+            lines = append(lines, current)
+            current, currentWidth, empty = indent, stringWidth(indent), true
+        } else if !empty {
+            current += gap
+            currentWidth += len(gap)
+        }
+
+        /* Split words too wide for even an empty line */
+        for currentWidth+wordWidth > width {
+            part := truncateToWidth(word, width-currentWidth)
+            if part == "" {
+                /* Not even one rune fits after indent, drop it */
+                if currentWidth == 0 {
+                    part = string([]rune(word)[0])
+                } else {
+                    current, currentWidth = "", 0
+                    continue
+                }
+            }
+            lines = append(lines, current+part)
+            word = word[len(part):]
+            wordWidth = stringWidth(word)
+            current, currentWidth, empty = indent, stringWidth(indent), true
+        }
+
+        if word == "" {
+            continue
+        }
+
+        current += word
+        currentWidth += wordWidth
+        empty = false
+    }
+
+    if !empty {
+        lines = append(lines, current)
+    }
+
+    return lines
+}
@@ -1,12 +1,12 @@
-package main
+package gopher
 
 import (
     "regexp"
     "strings"
 )
 
-func compileUserRestrictedFilesRegex(restrictedFiles string) []*regexp.Regexp {
-    Config.LogSystem("Compiling restricted file regular expressions\n")
+func (config *ServerConfig) compileUserRestrictedFilesRegex(restrictedFiles string) ([]*regexp.Regexp, *GophorError) {
+    config.LogSystem("Compiling restricted file regular expressions\n")
 
     /* Return slice */
     restrictedFilesRegex := make([]*regexp.Regexp, 0)
@@ -15,17 +15,17 @@ func compileUserRestrictedFilesRegex(restrictedFiles string) []*regexp.Regexp {
     for _, expr := range strings.Split(restrictedFiles, "\n") {
         regex, err := regexp.Compile(expr)
         if err != nil {
-            Config.LogSystemFatal("Failed compiling user restricted files regex: %s\n", expr)
+            return nil, &GophorError{ ConfigParseErr, err }
         }
         restrictedFilesRegex = append(restrictedFilesRegex, regex)
     }
 
-    return restrictedFilesRegex
+    return restrictedFilesRegex, nil
 }
 
 /* Iterate through restricted file expressions, check if file _is_ restricted */
-func isRestrictedFile(name string) bool {
-    for _, regex := range Config.RestrictedFiles {
+func (config *ServerConfig) isRestrictedFile(name string) bool {
+    for _, regex := range config.RestrictedFiles {
         if regex.MatchString(name) {
             return true
         }
@@ -0,0 +1,182 @@
+package gopher
+
+import (
+    "fmt"
+    "sync"
+    "bufio"
+    "regexp"
+    "strings"
+)
+
+type RewriteAction int
+const (
+    RewriteActionNone     RewriteAction = iota
+    RewriteActionRewrite  RewriteAction = iota
+    RewriteActionRedirect RewriteAction = iota
+    RewriteActionGone     RewriteAction = iota
+)
+
+/* RewriteRule:
+ * Regular expression matched against sanitized request
+ * paths, the action to take and (for rewrite / redirect)
+ * the target template, expanded with any submatches.
+ */
+type RewriteRule struct {
+    Regex  *regexp.Regexp
+    Action RewriteAction
+    Target string
+}
+
+/* RewriteRules:
+ * Holds onto the user supplied selector rewrite rules, checked
+ * in order with first match winning. Uses a RW mutex so the
+ * rules file can be reloaded while serving.
+ */
+type RewriteRules struct {
+    Path  string
+    Rules []*RewriteRule
+    Mutex sync.RWMutex
+    file  *reloadableFile
+}
+
+func NewRewriteRules(path string) *RewriteRules {
+    return &RewriteRules{
+        path,
+        make([]*RewriteRule, 0),
+        sync.RWMutex{},
+        &reloadableFile{ path, nil },
+    }
+}
+
+/* (Re)load the user rewrite rules file, if any. On error the current rules are kept */
+func (rr *RewriteRules) Load() *GophorError {
+    if rr.Path == "" {
+        return nil
+    }
+
+    rules := make([]*RewriteRule, 0)
+
+    lineNo := 0
+    var parseErr *GophorError
+    gophorErr := rr.file.scan(
+        func(scanner *bufio.Scanner) bool {
+            lineNo += 1
+            line := strings.TrimSpace(scanner.Text())
+
+            /* Skip empty lines and comments */
+            if line == "" || strings.HasPrefix(line, "#") {
+                return true
+            }
+
+            /* Line format: <action> <regex> [target] */
+            fields := strings.Fields(line)
+            if len(fields) < 2 {
+                parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid line %d", rr.Path, lineNo) }
+                return false
+            }
+
+            var action RewriteAction
+            switch strings.ToLower(fields[0]) {
+                case "rewrite":
+                    action = RewriteActionRewrite
+                case "redirect":
+                    action = RewriteActionRedirect
+                case "gone":
+                    action = RewriteActionGone
+                default:
+                    parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: unknown action '%s' on line %d", rr.Path, fields[0], lineNo) }
+                    return false
+            }
+
+            /* Gone takes no target, the others require one */
+            if (action == RewriteActionGone) != (len(fields) == 2) || len(fields) > 3 {
+                parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: wrong number of fields on line %d", rr.Path, lineNo) }
+                return false
+            }
+
+            regex, err := regexp.Compile(fields[1])
+            if err != nil {
+                parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: bad regex on line %d: %s", rr.Path, lineNo, err.Error()) }
+                return false
+            }
+
+            target := ""
+            if len(fields) == 3 {
+                target = fields[2]
+            }
+
+            rules = append(rules, &RewriteRule{ regex, action, target })
+            return true
+        },
+    )
+
+    if gophorErr != nil {
+        return gophorErr
+    } else if parseErr != nil {
+        return parseErr
+    }
+
+    /* Swap in the new rules */
+    rr.Mutex.Lock()
+    rr.Rules = rules
+    rr.Mutex.Unlock()
+
+    return nil
+}
+
+/* Match request path against rules, returning action and expanded target */
+func (rr *RewriteRules) Match(requestPath string) (RewriteAction, string) {
+    rr.Mutex.RLock()
+    defer rr.Mutex.RUnlock()
+
+    for _, rule := range rr.Rules {
+        submatches := rule.Regex.FindStringSubmatchIndex(requestPath)
+        if submatches == nil {
+            continue
+        }
+
+        target := string(rule.Regex.ExpandString(nil, rule.Target, requestPath, submatches))
+        return rule.Action, target
+    }
+
+    return RewriteActionNone, ""
+}
+
+/* Apply rewrite rules to sanitized request path, returning the action
+ * taken and the path to serve (rewritten, and sanitized, if need be).
+ * For redirects the path returned is the redirect target.
+ */
+func (config *ServerConfig) applyRewriteRules(requestPath string) (RewriteAction, string) {
+    action, target := config.RewriteRules.Match(requestPath)
+    switch action {
+        case RewriteActionRewrite:
+            return action, sanitizePath(target)
+        case RewriteActionRedirect:
+            return action, target
+        default:
+            return action, requestPath
+    }
+}
+
+/* Generate a redirect-style menu pointing to the new location of a selector */
+func (config *ServerConfig) generateRedirectMenu(target string, connHost *ConnHost) []byte {
+    /* Guess item type from what's at the new location */
+    itemType := TypeDirectory
+    if strings.HasPrefix(target, "URL:") {
+        itemType = TypeHtml
+    } else {
+        diskPath := config.UserDirs.Resolve(target)
+        stat, err := config.statContent(diskPath)
+        if err == nil && !stat.IsDir() {
+            itemType = config.FileSystem.GetItemType(diskPath, stat)
+        } else if mappedType, ok := config.TypeMap.Lookup(target); err != nil && ok {
+            itemType = mappedType
+        }
+    }
+
+    content := config.buildInfoLine("This item has moved to a new location:")
+    content = append(content, config.buildInfoLine("")...)
+    content = append(content, config.buildLine(itemType, target, target, connHost.Name, connHost.Port)...)
+    content = append(content, []byte(LastLine)...)
+    return content
+}
@@ -0,0 +1,458 @@
+package gopher
+
+import (
+    "os"
+    "fmt"
+    "math"
+    "path"
+    "sort"
+    "sync"
+    "time"
+    "bufio"
+    "regexp"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+/* SearchDoc:
+ * A single indexed file, either a text file or a gophermap
+ * (indexed by the text it displays, found under the selector
+ * of its directory). Modification time and size are kept so
+ * only changed files are re-indexed.
+ */
+type SearchDoc struct {
+    Path     string
+    Selector string
+    Title    string
+    Type     ItemType
+    ModTime  int64
+    Size     int64
+    Text     string
+    Terms    map[string]int
+}
+
+/* SearchResult:
+ * Matched document with the number of query terms it contains
+ * and its tf-idf score, used to rank results.
+ */
+type SearchResult struct {
+    Doc     *SearchDoc
+    Matched int
+    Score   float64
+}
+
+/* SearchIndex:
+ * Inverted index of the text files and gophermaps under the
+ * server root, mapping each term to the paths it appears in and
+ * how many times. Built in the background, then kept up to date
+ * by periodically walking the root and re-indexing only those
+ * files that have changed. Uses a RW mutex so searches can be
+ * served while the index is being updated.
+ */
+type SearchIndex struct {
+    Selector string
+    Root     string
+    Docs     map[string]*SearchDoc
+    Terms    map[string]map[string]int
+    Ready    bool
+    Mutex    sync.RWMutex
+
+    config   *ServerConfig
+}
+
+func NewSearchIndex(config *ServerConfig, selector, root string) *SearchIndex {
+    return &SearchIndex{
+        selector,
+        root,
+        make(map[string]*SearchDoc),
+        make(map[string]map[string]int),
+        false,
+        sync.RWMutex{},
+        config,
+    }
+}
+
+/* Start background goroutine (re)indexing the root every refreshTime,
+ * until stop is closed
+ */
+func (si *SearchIndex) Start(refreshTime time.Duration, stop <-chan struct{}) {
+    go func() {
+        for {
+            start := time.Now()
+            si.Update()
+
+            si.Mutex.RLock()
+            si.config.LogSystemDebug("Search index updated in %s: %d documents, %d terms\n", time.Since(start), len(si.Docs), len(si.Terms))
+            si.Mutex.RUnlock()
+
+            /* Sleep so we don't take up all the precious CPU time :) */
+            select {
+                case <-time.After(refreshTime):
+                case <-stop:
+                    return
+            }
+        }
+    }()
+}
+
+/* Walk the root, indexing new + changed files and dropping removed ones */
+func (si *SearchIndex) Update() {
+    seen := make(map[string]bool)
+    si.indexDir(si.Root, seen)
+
+    si.Mutex.Lock()
+    for docPath := range si.Docs {
+        if !seen[docPath] {
+            si.remove(docPath)
+        }
+    }
+    si.Ready = true
+    si.Mutex.Unlock()
+}
+
+/* Index directory at path and all below, skipping restricted, hidden and denied entries */
+func (si *SearchIndex) indexDir(dirPath string, seen map[string]bool) {
+    dirConfig := si.config.FileSystem.GetDirConfig(&FileSystemRequest{ dirPath, "", nil, 0, si.config }, dirPath)
+    if dirConfig.Deny {
+        return
+    }
+
+    /* Read files in directory */
+    files, err := si.config.readContentDir(dirPath)
+    if err != nil {
+        si.config.LogSystemError("Search index failed to enumerate dir %s: %s\n", dirPath, err.Error())
+        return
+    }
+
+    /* Entries hidden by the directory's gophermap */
+    gophermapPath := path.Join(dirPath, GophermapFileStr)
+    hidden := si.config.readGophermapHidden(gophermapPath)
+
+    for _, file := range files {
+        name := file.Name()
+        if si.config.isRestrictedFile(name) || hidden[name] || dirConfig.IsHidden(name) {
+            continue
+        }
+
+        itemPath := path.Join(dirPath, name)
+        switch {
+            case file.Mode() & os.ModeDir != 0:
+                si.indexDir(itemPath, seen)
+
+            case file.Mode() & os.ModeType == 0:
+                if name == GophermapFileStr {
+                    /* Gophermap found under its directory */
+                    si.indexFile(itemPath, dirPath, TypeDirectory, file, seen)
+                    continue
+                }
+
+                itemType, ok := dirConfig.ItemType(name)
+                if !ok {
+                    itemType = si.config.FileSystem.GetItemType(itemPath, file)
+                }
+                if itemType == TypeFile || isMarkdownFile(name) {
+                    si.indexFile(itemPath, itemPath, itemType, file, seen)
+                }
+
+            default:
+                /* Ignore */
+        }
+    }
+}
+
+/* Index file at path (if changed since last indexed), found under selector path */
+func (si *SearchIndex) indexFile(filePath, selectorPath string, itemType ItemType, stat os.FileInfo, seen map[string]bool) {
+    if stat.Size() > SearchMaxFileSize {
+        return
+    }
+    seen[filePath] = true
+
+    /* Skip if unchanged */
+    si.Mutex.RLock()
+    doc := si.Docs[filePath]
+    si.Mutex.RUnlock()
+    if doc != nil && doc.ModTime == stat.ModTime().UnixNano() && doc.Size == stat.Size() && doc.Type == itemType {
+        return
+    }
+
+    contents, gophorErr := si.config.bufferedRead(filePath)
+    if gophorErr != nil {
+        si.config.LogSystemError("Search index failed to read %s: %s\n", filePath, gophorErr.Error())
+        return
+    }
+
+    /* Gophermaps are indexed by the text they display */
+    text, title := string(contents), ""
+    if path.Base(filePath) == GophermapFileStr {
+        text, title = gophermapSearchText(text)
+    } else {
+        for _, line := range strings.Split(text, "\n") {
+            title = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+            if title != "" {
+                break
+            }
+        }
+    }
+
+    selector := si.config.UserDirs.Selector(selectorPath)
+    if title == "" {
+        title = selector
+    }
+
+    terms := make(map[string]int)
+    for _, term := range searchTerms(text) {
+        terms[term] += 1
+    }
+
+    si.Mutex.Lock()
+    si.remove(filePath)
+    si.Docs[filePath] = &SearchDoc{ filePath, selector, title, itemType, stat.ModTime().UnixNano(), stat.Size(), text, terms }
+    for term, count := range terms {
+        if si.Terms[term] == nil {
+            si.Terms[term] = make(map[string]int)
+        }
+        si.Terms[term][filePath] = count
+    }
+    si.Mutex.Unlock()
+}
+
+/* Remove document at path from the index, must be called with write lock held */
+func (si *SearchIndex) remove(docPath string) {
+    doc, ok := si.Docs[docPath]
+    if !ok {
+        return
+    }
+
+    for term := range doc.Terms {
+        delete(si.Terms[term], docPath)
+        if len(si.Terms[term]) == 0 {
+            delete(si.Terms, term)
+        }
+    }
+    delete(si.Docs, docPath)
+}
+
+/* Search index for query, returning results ranked by number of matched
+ * terms then tf-idf score.
+ */
+func (si *SearchIndex) Search(query string) []*SearchResult {
+    si.Mutex.RLock()
+    defer si.Mutex.RUnlock()
+
+    results := make(map[string]*SearchResult)
+    for _, term := range uniqueStrings(searchTerms(query)) {
+        postings := si.Terms[term]
+        if len(postings) == 0 {
+            continue
+        }
+
+        idf := math.Log(1 + float64(len(si.Docs))/float64(len(postings)))
+        for docPath, count := range postings {
+            result, ok := results[docPath]
+            if !ok {
+                result = &SearchResult{ si.Docs[docPath], 0, 0 }
+                results[docPath] = result
+            }
+            result.Matched += 1
+            result.Score   += (1 + math.Log(float64(count))) * idf
+        }
+    }
+
+    ranked := make([]*SearchResult, 0, len(results))
+    for _, result := range results {
+        ranked = append(ranked, result)
+    }
+    sort.Sort(byRank(ranked))
+
+    return ranked
+}
+
+/* Serve search request as a Handler, answered from the index */
+func (si *SearchIndex) Serve(w ResponseWriter, r *Request) {
+    r.Trace("Serving search: %q\n", r.Query)
+    w.Write(append(si.Respond(r), si.config.FooterText...))
+}
+
+/* Respond to search request with ranked result menu */
+func (si *SearchIndex) Respond(request *Request) []byte {
+    contents := si.config.buildLine(TypeInfo, "Search", "TITLE", NullHost, NullPort)
+    contents = append(contents, si.config.buildInfoLine("")...)
+
+    query := strings.TrimSpace(request.Query)
+    if query == "" {
+        contents = append(contents, si.config.buildInfoLine("Enter a search query to find text files and menus on this server.")...)
+        contents = append(contents, si.config.buildLine(TypeSearch, "Search", si.Selector, request.Host.Name, request.Host.Port)...)
+        return contents
+    }
+
+    si.Mutex.RLock()
+    ready := si.Ready
+    si.Mutex.RUnlock()
+    if !ready {
+        contents = append(contents, si.config.buildInfoLine("The search index is still being built, results may be incomplete.")...)
+        contents = append(contents, si.config.buildInfoLine("")...)
+    }
+
+    results := si.Search(query)
+    plural := "s"
+    if len(results) == 1 {
+        plural = ""
+    }
+    summary := fmt.Sprintf("%d result%s for \"%s\"", len(results), plural, query)
+    if len(results) > SearchMaxResults {
+        summary += fmt.Sprintf(", showing top %d", SearchMaxResults)
+        results = results[:SearchMaxResults]
+    }
+    contents = append(contents, si.config.buildInfoLine(summary)...)
+    contents = append(contents, si.config.buildInfoLine("")...)
+
+    /* Each result followed by a snippet of the matching text, terms matched once compiled */
+    termsRegex := searchTermsRegex(uniqueStrings(searchTerms(query)))
+    for _, result := range results {
+        contents = append(contents, si.config.buildLine(result.Doc.Type, result.Doc.Title, result.Doc.Selector, request.Host.Name, request.Host.Port)...)
+        for i, line := range wrapLine(searchSnippet(result.Doc.Text, termsRegex), si.config.PageWidth-2) {
+            if i == SearchSnippetLines {
+                break
+            }
+            contents = append(contents, si.config.buildInfoLine("  "+line)...)
+        }
+        contents = append(contents, si.config.buildInfoLine("")...)
+    }
+
+    contents = append(contents, si.config.buildLine(TypeSearch, "Search again", si.Selector, request.Host.Name, request.Host.Port)...)
+    return contents
+}
+
+/* Compile regex case-insensitively matching any of the terms, nil if none */
+func searchTermsRegex(terms []string) *regexp.Regexp {
+    if len(terms) == 0 {
+        return nil
+    }
+
+    quoted := make([]string, len(terms))
+    for i, term := range terms {
+        quoted[i] = regexp.QuoteMeta(term)
+    }
+    return regexp.MustCompile(`(?i)`+strings.Join(quoted, "|"))
+}
+
+/* Get snippet of text around the first match of terms regex (from searchTermsRegex()) */
+func searchSnippet(text string, termsRegex *regexp.Regexp) string {
+    start := 0
+    if termsRegex != nil {
+        if loc := termsRegex.FindStringIndex(text); loc != nil {
+            start = loc[0]
+        }
+    }
+
+    /* Start a little before the match, on a rune boundary */
+    start -= SearchSnippetWidth / 3
+    if start < 0 {
+        start = 0
+    }
+    for start > 0 && !utf8.RuneStart(text[start]) {
+        start -= 1
+    }
+
+    end := start + SearchSnippetWidth
+    if end > len(text) {
+        end = len(text)
+    }
+    for end < len(text) && !utf8.RuneStart(text[end]) {
+        end += 1
+    }
+
+    snippet := strings.Join(strings.Fields(text[start:end]), " ")
+    if start > 0 {
+        snippet = "..."+snippet
+    }
+    if end < len(text) {
+        snippet += "..."
+    }
+    return snippet
+}
+
+/* Split text into lower-case search terms, dropping single characters */
+func searchTerms(text string) []string {
+    terms := make([]string, 0)
+    for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+        if utf8.RuneCountInString(word) > 1 {
+            terms = append(terms, word)
+        }
+    }
+    return terms
+}
+
+func uniqueStrings(strs []string) []string {
+    found := make(map[string]bool)
+    unique := make([]string, 0)
+    for _, str := range strs {
+        if !found[str] {
+            found[str] = true
+            unique = append(unique, str)
+        }
+    }
+    return unique
+}
+
+/* Get text displayed by gophermap contents (info text + item names) and its title */
+func gophermapSearchText(contents string) (string, string) {
+    text := make([]string, 0)
+    title := ""
+    for _, line := range strings.Split(strings.Replace(contents, "\r\n", "\n", -1), "\n") {
+        switch parseLineType(line) {
+            case TypeInfoNotStated:
+                text = append(text, line)
+
+            case TypeTitle:
+                if title == "" {
+                    title = line[1:]
+                }
+                text = append(text, line[1:])
+
+            case TypeComment, TypeHiddenFile, TypeSubGophermap, TypeExec, TypeUserList, TypeUnknown:
+                /* Nothing displayed */
+
+            case TypeEnd, TypeEndBeginList:
+                return strings.Join(text, "\n"), title
+
+            default:
+                /* Item name, the first field minus the item type */
+                text = append(text, strings.SplitN(line, Tab, 2)[0][1:])
+        }
+    }
+    return strings.Join(text, "\n"), title
+}
+
+/* Get names hidden by '-' lines in gophermap at path, if any */
+func (config *ServerConfig) readGophermapHidden(gophermapPath string) map[string]bool {
+    hidden := make(map[string]bool)
+    if _, err := config.statContent(gophermapPath); err != nil {
+        return hidden
+    }
+
+    config.bufferedScan(gophermapPath,
+        func(scanner *bufio.Scanner) bool {
+            line := scanner.Text()
+            if parseLineType(line) == TypeHiddenFile {
+                hidden[line[1:]] = true
+            }
+            return true
+        },
+    )
+    return hidden
+}
+
+/* Rank results by matched terms, then score, then selector */
+type byRank []*SearchResult
+func (s byRank) Len() int           { return len(s) }
+func (s byRank) Less(i, j int) bool {
+    if s[i].Matched != s[j].Matched {
+        return s[i].Matched > s[j].Matched
+    } else if s[i].Score != s[j].Score {
+        return s[i].Score > s[j].Score
+    }
+    return s[i].Doc.Selector < s[j].Doc.Selector
+}
+func (s byRank) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
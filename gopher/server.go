@@ -0,0 +1,446 @@
+package gopher
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "bytes"
+    "io/fs"
+    "sync"
+    "time"
+    "strconv"
+)
+
+/* ServerOptions:
+ * Settings a Server is built from, matching gophor's command
+ * line flags. DefaultServerOptions() returns the flag defaults.
+ */
+type ServerOptions struct {
+    /* Base settings */
+    RootDir            string
+    Hostname           string
+    Port               int
+    BindAddr           string
+
+    /* Generated caps.txt information */
+    Description        string
+    AdminEmail         string
+    Geoloc             string
+
+    /* Content settings */
+    FooterText         string
+    NoFooterSeparator  bool
+    PageWidth          int
+    RestrictedFiles    string
+    ListSort           string
+    ListDirsFirst      bool
+    ListStyle          string
+    RenderMarkdown     bool
+    UserDir            string
+    TypeMapPath        string
+    RewriteRulesPath   string
+    ArchiveMaxSize     float64
+    DirArchive         string
+    DirArchiveMaxSize  float64
+    DirArchiveMaxFiles int
+    SearchSelector     string
+    SearchRefresh      time.Duration
+
+    /* Logging settings */
+    SystemLogPath      string
+    AccessLogPath      string
+    LogType            int
+    SystemLogLevel     string
+    AccessLogLevel     string
+    Debug              bool
+
+    /* Cache settings */
+    CacheCheckFreq     time.Duration
+    CacheSize          int
+    CacheFileMax       float64
+    CacheDisabled      bool
+
+    /* Content is read from this, if nil the directory at RootDir */
+    Source             fs.FS
+    MountTablePath     string
+
+    /* Requests are passed to this, if nil the server's FileSystem (and search) */
+    Handler            Handler
+}
+
+func DefaultServerOptions() *ServerOptions {
+    return &ServerOptions{
+        RootDir:            "/var/gopher",
+        Hostname:           "127.0.0.1",
+        Port:               70,
+        BindAddr:           "127.0.0.1",
+        Description:        "Gophor: a Gopher server in GoLang",
+        PageWidth:          80,
+        ListSort:           "name",
+        ListStyle:          "plain",
+        ArchiveMaxSize:     100,
+        DirArchiveMaxSize:  100,
+        DirArchiveMaxFiles: 1000,
+        SearchRefresh:      5 * time.Minute,
+        SystemLogLevel:     "info",
+        AccessLogLevel:     "info",
+        CacheCheckFreq:     60 * time.Second,
+        CacheSize:          50,
+        CacheFileMax:       0.5,
+    }
+}
+
+/* Server:
+ * A gophor server built from ServerOptions, passing each request
+ * to its Handler. Each Server holds its own settings in Config, so
+ * several may be in use at once.
+ */
+type Server struct {
+    Config     *ServerConfig
+    FileSystem *FileSystem
+    Handler    Handler
+
+    options    *ServerOptions
+    startOnce  sync.Once
+    policyOnce sync.Once
+    listeners  []net.Listener
+    stop       chan struct{}
+    closed     bool
+    mutex      sync.Mutex
+}
+
+/* Build new server from options, loading any supplied files. Nothing is
+ * served and no background goroutines are started until Serve() is called.
+ */
+func NewServer(options *ServerOptions) (*Server, *GophorError) {
+    /* Setup the server configuration instance and enter as much as we can right now */
+    config := new(ServerConfig)
+    config.RootDir        = options.RootDir
+    config.PageWidth      = options.PageWidth
+    config.RenderMarkdown = options.RenderMarkdown
+
+    /* Have to be set AFTER page width variable set */
+    config.FooterSeparator = !options.NoFooterSeparator
+    config.FooterText      = config.formatGophermapFooter(options.FooterText, config.FooterSeparator)
+
+    /* Setup Gophor logging system */
+    var gophorErr *GophorError
+    config.SystemLogger, config.AccessLogger, gophorErr = setupLogging(options.LogType, options.SystemLogPath, options.AccessLogPath)
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+
+    /* Set log levels, debug mode overrides system log level */
+    var ok bool
+    config.SystemLogLevel, ok = parseLogLevel(options.SystemLogLevel)
+    if !ok {
+        return nil, &GophorError{ ConfigParseErr, fmt.Errorf("unrecognized log level: %s", options.SystemLogLevel) }
+    }
+    config.AccessLogLevel, ok = parseLogLevel(options.AccessLogLevel)
+    if !ok {
+        return nil, &GophorError{ ConfigParseErr, fmt.Errorf("unrecognized log level: %s", options.AccessLogLevel) }
+    }
+    if options.Debug {
+        config.SystemLogLevel = LogLevelDebug
+        config.LogSystem("Debug mode enabled, tracing requests\n")
+    }
+
+    /* Parse default directory listing settings */
+    config.ListSort, config.ListDirsFirst, ok = parseListSort(options.ListSort)
+    if !ok {
+        return nil, &GophorError{ ConfigParseErr, fmt.Errorf("unrecognized list sort: %s", options.ListSort) }
+    }
+    config.ListDirsFirst = config.ListDirsFirst || options.ListDirsFirst
+    config.ListStyle, ok = parseListStyle(options.ListStyle)
+    if !ok {
+        return nil, &GophorError{ ConfigParseErr, fmt.Errorf("unrecognized list style: %s", options.ListStyle) }
+    }
+
+    /* Parse archive settings */
+    config.ArchiveMaxSize = int64(BytesInMegaByte * options.ArchiveMaxSize)
+
+    config.DirArchiveExt, ok = parseDirArchiveFormat(options.DirArchive)
+    if !ok {
+        return nil, &GophorError{ ConfigParseErr, fmt.Errorf("unrecognized directory archive format: %s", options.DirArchive) }
+    }
+    config.DirArchiveSize  = int64(BytesInMegaByte * options.DirArchiveMaxSize)
+    config.DirArchiveFiles = options.DirArchiveMaxFiles
+
+    /* Load user item type map */
+    config.TypeMap = NewTypeMap(options.TypeMapPath)
+    gophorErr = config.TypeMap.Load()
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+
+    /* Load user rewrite rules */
+    config.RewriteRules = NewRewriteRules(options.RewriteRulesPath)
+    gophorErr = config.RewriteRules.Load()
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+
+    /* Snapshot user home directories */
+    config.UserDirs, gophorErr = config.loadUserDirs(options.UserDir, options.RootDir)
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+    if options.UserDir != "" {
+        config.LogSystem("User directories enabled for %d users: ~user -> %s\n", len(config.UserDirs.Names), options.UserDir)
+    }
+
+    /* Compile user restricted files regex if supplied */
+    if options.RestrictedFiles != "" {
+        config.RestrictedFiles, gophorErr = config.compileUserRestrictedFilesRegex(options.RestrictedFiles)
+        if gophorErr != nil {
+            return nil, gophorErr
+        }
+
+        /* Setup the listDir function to use regex matching */
+        config.listDir = config._listDirRegexMatch
+    } else {
+        /* Setup the listDir function to skip regex matching */
+        config.listDir = config._listDir
+    }
+
+    /* Content source defaults to the root directory on disk, opened as a
+     * root so symlinks can't lead outside it (as with mounts)
+     */
+    source := options.Source
+    if source == nil {
+        root, err := os.OpenRoot(options.RootDir)
+        if err != nil {
+            return nil, &GophorError{ FileOpenErr, err }
+        }
+        source = root.FS()
+    }
+
+    /* Mount any further directories over it */
+    if options.MountTablePath != "" {
+        source, gophorErr = loadMountTable(options.MountTablePath, source)
+        if gophorErr != nil {
+            return nil, gophorErr
+        }
+        config.LogSystem("Loaded mount table: %s\n", options.MountTablePath)
+    }
+
+    /* Setup file cache */
+    config.FileSystem = new(FileSystem)
+    if !options.CacheDisabled {
+        config.FileSystem.Init(config, source, options.CacheSize, options.CacheFileMax)
+        config.LogSystem("File caching enabled with: maxcount=%d maxsize=%.3fMB\n", options.CacheSize, options.CacheFileMax)
+    } else {
+        /* File caching disabled, init with zero max size so nothing gets cached */
+        config.FileSystem.Init(config, source, 2, 0)
+        config.LogSystem("File caching disabled\n")
+    }
+
+    /* Default handler serves the filesystem, plus search if enabled */
+    handler := options.Handler
+    if handler == nil {
+        mux := NewServeMux()
+        mux.Handle("/", config.FileSystem)
+        if options.SearchSelector != "" {
+            config.Search = NewSearchIndex(config, sanitizePath(options.SearchSelector), "/")
+            mux.Handle(config.Search.Selector, config.Search)
+        }
+        handler = mux
+    }
+
+    return &Server{ config, config.FileSystem, handler, options, sync.Once{}, sync.Once{}, make([]net.Listener, 0), make(chan struct{}), false, sync.Mutex{} }, nil
+}
+
+/* Start the policy files, file monitor and search index. Done on first
+ * Serve() so any chroot has already been entered.
+ */
+func (s *Server) start() {
+    /* Before file monitor or any kind of new goroutines started,
+     * check if we need to cache generated policy files
+     */
+    s.cachePolicyFiles()
+
+    /* Start file cache freshness checker */
+    if !s.options.CacheDisabled {
+        s.Config.startFileMonitor(s.options.CacheCheckFreq, s.stop)
+        s.Config.LogSystem("File cache freshness monitor started with frequency: %s\n", s.options.CacheCheckFreq)
+    }
+
+    /* Start search index, built in the background */
+    if s.Config.Search != nil {
+        s.Config.Search.Start(s.options.SearchRefresh, s.stop)
+        s.Config.LogSystem("Search enabled at %s, index refresh frequency: %s\n", s.Config.Search.Selector, s.options.SearchRefresh)
+    }
+}
+
+/* Cache generated policy files, only once as the cache isn't locked */
+func (s *Server) cachePolicyFiles() {
+    s.policyOnce.Do(func() {
+        s.Config.cachePolicyFiles(s.options.Description, s.options.AdminEmail, s.options.Geoloc)
+    })
+}
+
+/* Accept connections on listener, serving each in its own goroutine. Only
+ * returns once the server is closed. If the server's port is zero, the
+ * listener's port is used in generated selectors.
+ */
+func (s *Server) Serve(l net.Listener) error {
+    s.mutex.Lock()
+    if s.closed {
+        s.mutex.Unlock()
+        return nil
+    }
+    s.listeners = append(s.listeners, l)
+    s.mutex.Unlock()
+
+    s.startOnce.Do(s.start)
+
+    host := &ConnHost{ s.options.Hostname, strconv.Itoa(s.options.Port) }
+    if addr, ok := l.Addr().(*net.TCPAddr); ok && s.options.Port == 0 {
+        host.Port = strconv.Itoa(addr.Port)
+    }
+    listener := &GophorListener{ l, host }
+
+    s.Config.LogSystem("Listening on: gopher://%s\n", l.Addr())
+    for {
+        newConn, err := listener.Accept()
+        if err != nil {
+            if s.isClosed() {
+                return nil
+            }
+            s.Config.LogSystemError("Error accepting connection: %s\n", err.Error())
+            continue
+        }
+
+        /* Run this in it's own goroutine so we can go straight back to accepting */
+        go NewWorker(s.Config, newConn, s.Handler).Serve()
+    }
+}
+
+/* Listen on the bind address and port from the server's options, then Serve() */
+func (s *Server) ListenAndServe() error {
+    l, err := net.Listen("tcp", s.options.BindAddr+":"+strconv.Itoa(s.options.Port))
+    if err != nil {
+        return err
+    }
+    return s.Serve(l)
+}
+
+/* Stop accepting connections, closing all listeners and stopping the
+ * file monitor and search indexer
+ */
+func (s *Server) Close() {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    if s.closed {
+        return
+    }
+
+    /* Stop background goroutines, then stop listening */
+    s.closed = true
+    close(s.stop)
+    for _, l := range s.listeners {
+        l.Close()
+    }
+}
+
+func (s *Server) isClosed() bool {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    return s.closed
+}
+
+/* Reload any user supplied files that can be changed while running.
+ * They're re-read from the directories they were first loaded from,
+ * even if chroot'd since.
+ */
+func (s *Server) Reload() {
+    gophorErr := s.Config.TypeMap.Load()
+    if gophorErr != nil {
+        s.Config.LogSystemError("Failed to reload type map, keeping current: %s\n", gophorErr.Error())
+    } else if s.Config.TypeMap.Path != "" {
+        s.Config.LogSystem("Reloaded type map: %s\n", s.Config.TypeMap.Path)
+    }
+
+    gophorErr = s.Config.RewriteRules.Load()
+    if gophorErr != nil {
+        s.Config.LogSystemError("Failed to reload rewrite rules, keeping current: %s\n", gophorErr.Error())
+    } else if s.Config.RewriteRules.Path != "" {
+        s.Config.LogSystem("Reloaded rewrite rules: %s\n", s.Config.RewriteRules.Path)
+    }
+}
+
+/* Render selector as served to host by the filesystem, after rewrite rules
+ * and user directories as a worker would, for exporting content
+ */
+func (s *Server) Render(selector string, host *ConnHost) ([]byte, *GophorError) {
+    s.cachePolicyFiles()
+
+    action, requestPath := s.Config.applyRewriteRules(sanitizePath(selector))
+    switch action {
+        case RewriteActionRedirect:
+            return s.Config.generateRedirectMenu(requestPath, host), nil
+        case RewriteActionGone:
+            return nil, &GophorError{ GoneErr, nil }
+        default:
+            /* Rewritten or no matching rule */
+    }
+
+    buf := &bytes.Buffer{}
+    gophorErr := s.Config.FileSystem.HandleRequest(buf, &FileSystemRequest{ s.Config.UserDirs.Resolve(requestPath), "", host, 0, s.Config })
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+    return buf.Bytes(), nil
+}
+
+/* ContentItem:
+ * A selector served from content under the root, and its item type.
+ */
+type ContentItem struct {
+    Selector string
+    Type     ItemType
+}
+
+/* Get every file and directory served from content under the root, in
+ * walk order, for exporting. Restricted files, directory config files
+ * and denied directories are left out as they're never served. Generated
+ * selectors (feeds, archives and so on) and user directories aren't
+ * included, they can only be found by following links.
+ */
+func (s *Server) ContentItems() []*ContentItem {
+    items := make([]*ContentItem, 0)
+    request := &FileSystemRequest{ "/", "", nil, 0, s.Config }
+    fs.WalkDir(s.Config.FileSystem.Source, ".", func(sourceFilePath string, entry fs.DirEntry, err error) error {
+        if err != nil {
+            return nil
+        }
+
+        filePath := sanitizePath(sourceFilePath)
+        if filePath != "/" && (s.Config.isRestrictedFile(entry.Name()) || entry.Name() == DirConfigFileStr) {
+            if entry.IsDir() {
+                return fs.SkipDir
+            }
+            return nil
+        }
+
+        /* Stat to follow symlinks, as when served */
+        stat, err := s.Config.statContent(filePath)
+        switch {
+            case err != nil:
+                return nil
+
+            case stat.IsDir():
+                /* Denied directories' contents are too */
+                if s.Config.FileSystem.GetDirConfig(request, filePath).Deny {
+                    return fs.SkipDir
+                }
+                items = append(items, &ContentItem{ filePath, TypeDirectory })
+
+            case stat.Mode() & os.ModeType == 0:
+                items = append(items, &ContentItem{ filePath, s.Config.FileSystem.GetItemType(filePath, stat) })
+        }
+        return nil
+    })
+    return items
+}
@@ -0,0 +1,206 @@
+package gopher
+
+import (
+    "os"
+    "io"
+    "bytes"
+    "sync"
+    "unicode/utf8"
+    "container/list"
+)
+
+/* MagicSignature:
+ * A sequence of bytes expected at a given offset
+ * in a file's contents, and the item type a file
+ * starting with them should be served as.
+ */
+type MagicSignature struct {
+    Offset int
+    Magic  []byte
+    Type   ItemType
+}
+
+/* Checked in order, first match wins. Text-like formats (html, xml)
+ * are checked before falling back to the generic UTF-8 text check.
+ */
+var MagicSignatures = []MagicSignature{
+    /* Images */
+    MagicSignature{ 0, []byte("\x89PNG\r\n\x1a\n"), TypeImage },
+    MagicSignature{ 0, []byte("GIF87a"), TypeImage },
+    MagicSignature{ 0, []byte("GIF89a"), TypeImage },
+    MagicSignature{ 0, []byte("\xff\xd8\xff"), TypeImage },
+    MagicSignature{ 8, []byte("WEBP"), TypeImage },
+    MagicSignature{ 0, []byte("II*\x00"), TypeImage },
+    MagicSignature{ 0, []byte("MM\x00*"), TypeImage },
+
+    /* Documents */
+    MagicSignature{ 0, []byte("%PDF-"), TypeDoc },
+    MagicSignature{ 0, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), TypeDoc },
+
+    /* Archives */
+    MagicSignature{ 0, []byte("\x1f\x8b"), TypeBinArchive },
+    MagicSignature{ 0, []byte("PK\x03\x04"), TypeBinArchive },
+    MagicSignature{ 0, []byte("PK\x05\x06"), TypeBinArchive },
+    MagicSignature{ 0, []byte("BZh"), TypeBinArchive },
+    MagicSignature{ 0, []byte("\xfd7zXZ\x00"), TypeBinArchive },
+    MagicSignature{ 0, []byte("7z\xbc\xaf\x27\x1c"), TypeBinArchive },
+    MagicSignature{ 0, []byte("\x28\xb5\x2f\xfd"), TypeBinArchive },
+    MagicSignature{ 0, []byte("Rar!\x1a\x07"), TypeBinArchive },
+    MagicSignature{ 257, []byte("ustar"), TypeBinArchive },
+
+    /* Executables */
+    MagicSignature{ 0, []byte("\x7fELF"), TypeBin },
+    MagicSignature{ 0, []byte("MZ"), TypeBin },
+    MagicSignature{ 0, []byte("\xfe\xed\xfa\xce"), TypeBin },
+    MagicSignature{ 0, []byte("\xfe\xed\xfa\xcf"), TypeBin },
+    MagicSignature{ 0, []byte("\xcf\xfa\xed\xfe"), TypeBin },
+
+    /* Audio */
+    MagicSignature{ 0, []byte("OggS"), TypeAudio },
+    MagicSignature{ 0, []byte("fLaC"), TypeAudio },
+    MagicSignature{ 0, []byte("ID3"), TypeAudio },
+    MagicSignature{ 0, []byte("MThd"), TypeAudio },
+    MagicSignature{ 8, []byte("WAVE"), TypeAudio },
+    MagicSignature{ 8, []byte("AIFF"), TypeAudio },
+
+    /* Video */
+    MagicSignature{ 4, []byte("ftyp"), TypeVideo },
+    MagicSignature{ 0, []byte("\x1a\x45\xdf\xa3"), TypeVideo },
+    MagicSignature{ 8, []byte("AVI "), TypeVideo },
+
+    /* Text-based markup */
+    MagicSignature{ 0, []byte("<?xml"), TypeXml },
+    MagicSignature{ 0, []byte("<!DOCTYPE html"), TypeHtml },
+    MagicSignature{ 0, []byte("<!doctype html"), TypeHtml },
+    MagicSignature{ 0, []byte("<html"), TypeHtml },
+}
+
+/* Read the first SniffBufSize bytes of file at path and detect item type */
+func (config *ServerConfig) sniffItemType(path string) (ItemType, *GophorError) {
+    fd, err := config.openContent(path)
+    if err != nil {
+        return TypeDefault, &GophorError{ FileOpenErr, err }
+    }
+    defer fd.Close()
+
+    buf := make([]byte, SniffBufSize)
+    count, err := io.ReadFull(fd, buf)
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+        return TypeDefault, &GophorError{ FileReadErr, err }
+    }
+
+    return sniffContents(buf[:count]), nil
+}
+
+/* Detect item type from the leading bytes of a file */
+func sniffContents(data []byte) ItemType {
+    /* Empty files are as good as text */
+    if len(data) == 0 {
+        return TypeFile
+    }
+
+    /* Check against known magic signatures */
+    for _, sig := range MagicSignatures {
+        end := sig.Offset+len(sig.Magic)
+        if end <= len(data) && bytes.Equal(data[sig.Offset:end], sig.Magic) {
+            return sig.Type
+        }
+    }
+
+    /* No signature found, see if this looks like text */
+    if isTextContents(data) {
+        return TypeFile
+    }
+
+    return TypeDefault
+}
+
+/* Check data is valid UTF-8 without NUL or non-whitespace control characters.
+ * As data is usually a truncated read, allow an incomplete rune at the end.
+ */
+func isTextContents(data []byte) bool {
+    for len(data) > 0 {
+        r, size := utf8.DecodeRune(data)
+        if r == utf8.RuneError && size <= 1 {
+            /* Invalid, unless this is a partial rune cut off by read size */
+            return len(data) < utf8.UTFMax && !utf8.FullRune(data)
+        }
+
+        if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != '\v' && r != 0x1b {
+            return false
+        } else if r == 0x7f {
+            return false
+        }
+
+        data = data[size:]
+    }
+    return true
+}
+
+/* ItemTypeCache:
+ * Holds onto sniffed item types so directory listings don't have to
+ * reread files on each request. Entries are keyed by path, modification
+ * time and size, so a changed file misses and its stale entry is pushed
+ * out as the least recently used once the cache is full.
+ */
+type ItemTypeCache struct {
+    Map   map[string]*ItemTypeEntry
+    List  *list.List
+    Mutex sync.Mutex
+    Size  int
+
+    config *ServerConfig
+}
+
+type ItemTypeEntry struct {
+    Element *list.Element
+    Type    ItemType
+}
+
+func NewItemTypeCache(config *ServerConfig, size int) *ItemTypeCache {
+    return &ItemTypeCache{
+        make(map[string]*ItemTypeEntry),
+        list.New(),
+        sync.Mutex{},
+        size,
+        config,
+    }
+}
+
+/* Get item type for file at path, sniffing contents if not cached for this version of the file */
+func (c *ItemTypeCache) Get(path string, stat os.FileInfo) ItemType {
+    key := statCacheKey(path, stat)
+
+    c.Mutex.Lock()
+    entry, ok := c.Map[key]
+    if ok {
+        c.List.MoveToFront(entry.Element)
+    }
+    c.Mutex.Unlock()
+
+    if ok {
+        return entry.Type
+    }
+
+    itemType, gophorErr := c.config.sniffItemType(path)
+    if gophorErr != nil {
+        /* Don't cache failures, file may become readable */
+        c.config.LogSystemError("Failed to sniff item type for %s: %s\n", path, gophorErr.Error())
+        return itemType
+    }
+
+    c.Mutex.Lock()
+    if _, ok := c.Map[key]; !ok {
+        c.Map[key] = &ItemTypeEntry{ c.List.PushFront(key), itemType }
+
+        /* Push out least recently used if size limit reached */
+        if c.List.Len() > c.Size {
+            element := c.List.Back()
+            delete(c.Map, element.Value.(string))
+            c.List.Remove(element)
+        }
+    }
+    c.Mutex.Unlock()
+
+    return itemType
+}
@@ -0,0 +1,157 @@
+package gopher
+
+import (
+    "path"
+    "sync"
+    "fmt"
+    "bufio"
+    "strings"
+)
+
+/* TypeMap:
+ * Holds onto the item type mappings used when listing files,
+ * built from the default FileExtMap plus any user supplied
+ * type-map file entries. Lookups are performed in order:
+ * glob patterns (forced), exact filenames, then extensions.
+ * Uses a RW mutex so the file can be reloaded while serving.
+ */
+type TypeMap struct {
+    Path  string
+    Globs []*TypeMapGlob
+    Names map[string]ItemType
+    Exts  map[string]ItemType
+    Mutex sync.RWMutex
+    file  *reloadableFile
+}
+
+/* TypeMapGlob:
+ * Glob pattern matched against a file's base name,
+ * and the item type it forces.
+ */
+type TypeMapGlob struct {
+    Pattern string
+    Type    ItemType
+}
+
+func NewTypeMap(path string) *TypeMap {
+    return &TypeMap{
+        path,
+        make([]*TypeMapGlob, 0),
+        make(map[string]ItemType),
+        copyFileExtMap(),
+        sync.RWMutex{},
+        &reloadableFile{ path, nil },
+    }
+}
+
+/* (Re)load the user type-map file, if any. On error the current mappings are kept */
+func (tm *TypeMap) Load() *GophorError {
+    if tm.Path == "" {
+        return nil
+    }
+
+    globs := make([]*TypeMapGlob, 0)
+    names := make(map[string]ItemType)
+    exts  := copyFileExtMap()
+
+    lineNo := 0
+    var parseErr *GophorError
+    gophorErr := tm.file.scan(
+        func(scanner *bufio.Scanner) bool {
+            lineNo += 1
+            line := strings.TrimSpace(scanner.Text())
+
+            /* Skip empty lines and comments */
+            if line == "" || strings.HasPrefix(line, "#") {
+                return true
+            }
+
+            /* Line format: <item type> <pattern> [pattern ...] */
+            fields := strings.Fields(line)
+            if len(fields) < 2 || len(fields[0]) != 1 {
+                parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: invalid line %d", tm.Path, lineNo) }
+                return false
+            }
+            itemType := ItemType(fields[0][0])
+
+            for _, pattern := range fields[1:] {
+                pattern = strings.ToLower(pattern)
+                switch {
+                    case strings.ContainsAny(pattern, "*?["):
+                        /* Check pattern is valid before accepting */
+                        if _, err := path.Match(pattern, ""); err != nil {
+                            parseErr = &GophorError{ ConfigParseErr, fmt.Errorf("%s: bad pattern on line %d", tm.Path, lineNo) }
+                            return false
+                        }
+                        globs = append(globs, &TypeMapGlob{ pattern, itemType })
+
+                    case strings.HasPrefix(pattern, "."):
+                        exts[pattern] = itemType
+
+                    default:
+                        names[pattern] = itemType
+                }
+            }
+
+            return true
+        },
+    )
+
+    if gophorErr != nil {
+        return gophorErr
+    } else if parseErr != nil {
+        return parseErr
+    }
+
+    /* Swap in the new mappings */
+    tm.Mutex.Lock()
+    tm.Globs = globs
+    tm.Names = names
+    tm.Exts  = exts
+    tm.Mutex.Unlock()
+
+    return nil
+}
+
+/* Look up item type for named file, returning false if no mapping found */
+func (tm *TypeMap) Lookup(name string) (ItemType, bool) {
+    name = strings.ToLower(path.Base(name))
+
+    tm.Mutex.RLock()
+    defer tm.Mutex.RUnlock()
+
+    /* Forced types on glob patterns come first */
+    for _, glob := range tm.Globs {
+        if ok, _ := path.Match(glob.Pattern, name); ok {
+            return glob.Type, true
+        }
+    }
+
+    /* Then exact file names */
+    if itemType, ok := tm.Names[name]; ok {
+        return itemType, true
+    }
+
+    /* Compound extensions (e.g. ".tar.gz") are tried longest first,
+     * then down to the last extension.
+     */
+    for i := 0; i < len(name); i += 1 {
+        if name[i] != '.' {
+            continue
+        }
+
+        if itemType, ok := tm.Exts[name[i:]]; ok {
+            return itemType, true
+        }
+    }
+
+    return TypeDefault, false
+}
+
+func copyFileExtMap() map[string]ItemType {
+    exts := make(map[string]ItemType, len(FileExtMap))
+    for ext, itemType := range FileExtMap {
+        exts[ext] = itemType
+    }
+    return exts
+}
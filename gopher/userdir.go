@@ -0,0 +1,157 @@
+package gopher
+
+import (
+    "path"
+    "sort"
+    "bufio"
+    "strings"
+    "strconv"
+    "path/filepath"
+)
+
+/* UserDirs:
+ * Snapshot of user home directories taken from /etc/passwd
+ * BEFORE chroot (as we can't read it after). Maps selectors
+ * beginning '/~name' to DirName inside that user's home, e.g.
+ * '/~alice/phlog' -> '/home/alice/public_gopher/phlog'. Home
+ * paths are as seen from within the chroot, so they must be
+ * reachable from the server root (e.g. via bind mount).
+ */
+type UserDirs struct {
+    DirName string
+    Homes   map[string]string
+    Bases   map[string]string
+    Names   []string
+
+    config  *ServerConfig
+}
+
+/* Take passwd snapshot. If dirName is empty, user dirs are disabled */
+func (config *ServerConfig) loadUserDirs(dirName, serverRoot string) (*UserDirs, *GophorError) {
+    userDirs := &UserDirs{ dirName, make(map[string]string), make(map[string]string), make([]string, 0), config }
+    if dirName == "" {
+        return userDirs, nil
+    }
+
+    /* Get absolute server root so we can translate home paths into the chroot */
+    root, err := filepath.Abs(serverRoot)
+    if err != nil {
+        return nil, &GophorError{ PathEnumerationErr, err }
+    }
+
+    gophorErr := bufferedScanFile("/etc/passwd",
+        func(scanner *bufio.Scanner) bool {
+            /* Line format: name:password:uid:gid:gecos:home:shell */
+            fields := strings.Split(scanner.Text(), ":")
+            if len(fields) < 7 {
+                return true
+            }
+
+            /* Never serve root's home */
+            uid, err := strconv.Atoi(fields[2])
+            if err != nil || uid == 0 {
+                return true
+            }
+
+            /* Translate home into path from within chroot */
+            home := path.Clean(fields[5])
+            if home == root {
+                home = "/"
+            } else if strings.HasPrefix(home, root+"/") {
+                home = strings.TrimPrefix(home, root)
+            }
+
+            userDirs.Homes[fields[0]] = home
+            userDirs.Bases[path.Join(home, dirName)] = fields[0]
+            userDirs.Names = append(userDirs.Names, fields[0])
+            return true
+        },
+    )
+    if gophorErr != nil {
+        return nil, gophorErr
+    }
+
+    sort.Strings(userDirs.Names)
+    return userDirs, nil
+}
+
+/* Translate request path into path on disk if it refers to a user directory */
+func (ud *UserDirs) Resolve(requestPath string) string {
+    if ud.DirName == "" || !strings.HasPrefix(requestPath, "/~") {
+        return requestPath
+    }
+
+    /* Split into user name and remaining path, or a query on the user directory itself */
+    name, rest := requestPath[2:], ""
+    if i := strings.IndexAny(name, "/?"); i >= 0 {
+        name, rest = name[:i], name[i:]
+    }
+
+    home, ok := ud.Homes[name]
+    if !ok && rest == "" && ud.config.DirArchiveExt != "" && strings.HasSuffix(name, ud.config.DirArchiveExt) {
+        /* Archive of the user directory itself, e.g. '/~alice.tar' */
+        name, rest = strings.TrimSuffix(name, ud.config.DirArchiveExt), ud.config.DirArchiveExt
+        home, ok = ud.Homes[name]
+    }
+    if !ok {
+        /* Unknown user, leave as-is to 404 */
+        return requestPath
+    }
+
+    userPath := path.Join(home, ud.DirName)
+    if !strings.HasPrefix(rest, "/") {
+        /* Query or archive extension stays attached to the directory, e.g. '/~alice?page=2' */
+        return userPath+rest
+    }
+    return path.Join(userPath, rest)
+}
+
+/* Translate path on disk back into a selector, the inverse of Resolve() */
+func (ud *UserDirs) Selector(diskPath string) string {
+    if ud.DirName == "" {
+        return diskPath
+    }
+
+    /* Walk up the path looking for a user directory base */
+    for base := diskPath; base != "/" && base != "."; base = path.Dir(base) {
+        if name, ok := ud.Bases[base]; ok {
+            return "/~"+name+strings.TrimPrefix(diskPath, base)
+        }
+    }
+
+    return diskPath
+}
+
+/* Get sorted names of users who currently have a user directory */
+func (ud *UserDirs) ListUsers() []string {
+    users := make([]string, 0)
+    if ud.DirName == "" {
+        return users
+    }
+
+    for _, name := range ud.Names {
+        stat, err := ud.config.statContent(path.Join(ud.Homes[name], ud.DirName))
+        if err == nil && stat.IsDir() {
+            users = append(users, name)
+        }
+    }
+
+    return users
+}
+
+/* GophermapUserListing:
+ * An implementation of GophermapSection that lists all users
+ * with a user directory, enumerated on each Render() so newly
+ * created user directories appear without a reload.
+ */
+type GophermapUserListing struct {
+    config *ServerConfig
+}
+
+func (s *GophermapUserListing) Render(request *FileSystemRequest) ([]byte, *GophorError) {
+    contents := make([]byte, 0)
+    for _, name := range s.config.UserDirs.ListUsers() {
+        contents = append(contents, s.config.buildLine(TypeDirectory, "~"+name, "/~"+name, request.Host.Name, request.Host.Port)...)
+    }
+    return contents, nil
+}
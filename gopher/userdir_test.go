@@ -0,0 +1,98 @@
+package gopher
+
+import (
+    "io"
+    "bytes"
+    "strings"
+    "testing"
+    "archive/tar"
+    "path/filepath"
+)
+
+/* Create server with a single user alice, whose user directory is
+ * /home/alice/public_gopher under the root, containing files. If
+ * options is nil the defaults are used, with logging and caching off.
+ */
+func newUserDirServer(t *testing.T, options *ServerOptions, files map[string]string) *Server {
+    if options == nil {
+        options = DefaultServerOptions()
+        options.LogType       = 1
+        options.CacheDisabled = true
+    }
+    options.RootDir = t.TempDir()
+
+    server, gophorErr := NewServer(options)
+    if gophorErr != nil {
+        t.Fatalf("Error creating server: %s", gophorErr.Error())
+    }
+    server.Config.UserDirs = &UserDirs{
+        "public_gopher",
+        map[string]string{ "alice": "/home/alice" },
+        map[string]string{ "/home/alice/public_gopher": "alice" },
+        []string{ "alice" },
+        server.Config,
+    }
+
+    writeTestFiles(t, filepath.Join(options.RootDir, "home", "alice", "public_gopher"), files)
+    return server
+}
+
+/* Render selector, failing the test on error */
+func renderSelector(t *testing.T, server *Server, selector string) string {
+    contents, gophorErr := server.Render(selector, &ConnHost{ "localhost", "70" })
+    if gophorErr != nil {
+        t.Fatalf("Error rendering %s: %s", selector, gophorErr.Error())
+    }
+    return string(contents)
+}
+
+func TestUserDirPhlogPaging(t *testing.T) {
+    server := newUserDirServer(t, nil, map[string]string{
+        DirConfigFileStr:   "phlog\nphlog-page-size: 1\n",
+        "20200101-old.txt": "Old post\n",
+        "20210101-new.txt": "New post\n",
+    })
+
+    index := renderSelector(t, server, "/~alice")
+    for _, selector := range []string{ "/~alice?page=2", "/~alice?year=2020" } {
+        if !strings.Contains(index, "\t"+selector+"\t") {
+            t.Errorf("Phlog index missing link to %s:\n%s", selector, index)
+        }
+    }
+
+    if page := renderSelector(t, server, "/~alice?page=2"); !strings.Contains(page, "Page 2 of 2") {
+        t.Errorf("Unexpected second page:\n%s", page)
+    }
+    if year := renderSelector(t, server, "/~alice?year=2020"); !strings.Contains(year, "Old post") {
+        t.Errorf("Unexpected 2020 archive:\n%s", year)
+    }
+}
+
+func TestUserDirArchive(t *testing.T) {
+    options := DefaultServerOptions()
+    options.LogType       = 1
+    options.CacheDisabled = true
+    options.DirArchive    = "tar"
+    server := newUserDirServer(t, options, map[string]string{
+        "notes.txt": "Some notes\n",
+    })
+
+    if listing := renderSelector(t, server, "/~alice"); !strings.Contains(listing, "\t/~alice.tar\t") {
+        t.Errorf("Listing missing link to /~alice.tar:\n%s", listing)
+    }
+
+    found := false
+    reader := tar.NewReader(bytes.NewReader([]byte(renderSelector(t, server, "/~alice.tar"))))
+    for {
+        header, err := reader.Next()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            t.Fatalf("Error reading archive: %s", err.Error())
+        }
+        found = found || strings.HasSuffix(header.Name, "/notes.txt")
+    }
+    if !found {
+        t.Errorf("Archive of /~alice missing notes.txt")
+    }
+}
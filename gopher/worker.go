@@ -1,16 +1,25 @@
-package main
+package gopher
 
 import (
     "path"
+    "bytes"
     "strings"
+    "sync/atomic"
 )
 
+/* Incremented for each new worker, used to tag request traces */
+var workerIdCounter uint64
+
 type Worker struct {
-    Conn *GophorConn
+    Conn    *GophorConn
+    Handler Handler
+    Id      uint64
+
+    config  *ServerConfig
 }
 
-func NewWorker(conn *GophorConn) *Worker {
-    return &Worker{ conn }
+func NewWorker(config *ServerConfig, conn *GophorConn, handler Handler) *Worker {
+    return &Worker{ conn, handler, atomic.AddUint64(&workerIdCounter, 1), config }
 }
 
 func (worker *Worker) Serve() {
@@ -31,7 +40,7 @@ func (worker *Worker) Serve() {
         /* Buffered read from listener */
         count, err = worker.Conn.Read(buf)
         if err != nil {
-            Config.LogSystemError("Error reading from socket on port %s: %s\n", worker.Conn.Host.Port, err.Error())
+            worker.config.LogSystemError("Error reading from socket on port %s: %s\n", worker.Conn.Host.Port, err.Error())
             return
         }
 
@@ -46,7 +55,7 @@ func (worker *Worker) Serve() {
 
         /* Hit max read chunk size, send error + close connection */
         if iter == MaxSocketReadChunks {
-            Config.LogSystemError("Reached max socket read size %d. Closing connection...\n", MaxSocketReadChunks*SocketReadBufSize)
+            worker.config.LogSystemError("Reached max socket read size %d. Closing connection...\n", MaxSocketReadChunks*SocketReadBufSize)
             return
         }
 
@@ -54,12 +63,14 @@ func (worker *Worker) Serve() {
         iter += 1
     }
 
+    worker.Trace("Received %d bytes from %s\n", len(received), worker.Conn.RemoteAddr())
+
     /* Handle request */
     gophorErr := worker.RespondGopher(received)
 
     /* Handle any error */
     if gophorErr != nil {
-        Config.LogSystemError("%s\n", gophorErr.Error())
+        worker.config.LogSystemError("%s\n", gophorErr.Error())
 
         /* Generate response bytes from error code */
         response := generateGopherErrorResponseFromCode(gophorErr.Code)
@@ -79,20 +90,32 @@ func (worker *Worker) SendRaw(b []byte) *GophorError {
     } else if count != len(b) {
         return &GophorError{ SocketWriteCountErr, nil }
     }
+    worker.Trace("Wrote %d bytes\n", count)
     return nil
 }
 
 func (worker *Worker) Log(format string, args ...interface{}) {
-    Config.LogAccess(worker.Conn.RemoteAddr().String(), format, args...)
+    worker.config.LogAccess(worker.Conn.RemoteAddr().String(), format, args...)
 }
 
 func (worker *Worker) LogError(format string, args ...interface{}) {
-    Config.LogAccessError(worker.Conn.RemoteAddr().String(), format, args...)
+    worker.config.LogAccessError(worker.Conn.RemoteAddr().String(), format, args...)
+}
+
+func (worker *Worker) Trace(format string, args ...interface{}) {
+    worker.config.LogTrace(worker.Id, format, args...)
 }
 
 func (worker *Worker) RespondGopher(data []byte) *GophorError {
     /* According to Gopher spec, only read up to first Tab or Crlf */
     dataStr := readUpToFirstTabOrCrlf(data)
+    worker.Trace("Parsed selector: %q\n", dataStr)
+
+    /* Keep hold of any search query following the selector */
+    query := readQuery(data)
+    if query != "" {
+        worker.Trace("Parsed query: %q\n", query)
+    }
 
     /* Handle URL request if presented */
     lenBefore := len(dataStr)
@@ -108,17 +131,61 @@ func (worker *Worker) RespondGopher(data []byte) *GophorError {
 
     /* Sanitize supplied path */
     requestPath := sanitizePath(dataStr)
+    worker.Trace("Sanitized path: %s\n", requestPath)
 
-    /* Append lastline */
-    response, gophorErr := Config.FileSystem.HandleRequest(requestPath, worker.Conn.Host)
-    if gophorErr != nil {
+    /* Check request against rewrite rules */
+    action, target := worker.config.applyRewriteRules(requestPath)
+    switch action {
+        case RewriteActionRewrite:
+            worker.Trace("Rewrote %s -> %s\n", requestPath, target)
+
+        case RewriteActionRedirect:
+            worker.Log("Redirecting %s -> %s\n", requestPath, target)
+            return worker.SendRaw(worker.config.generateRedirectMenu(target, worker.Conn.Host))
+
+        case RewriteActionGone:
+            worker.Log("Gone: %s\n", requestPath)
+            return &GophorError{ GoneErr, nil }
+
+        default:
+            /* No matching rule */
+    }
+    requestPath = target
+
+    /* Pass to handler, keeping hold of any error to respond with */
+    w := &responseWriter{ worker, nil }
+    worker.Handler.Serve(w, &Request{ requestPath, query, worker.Conn.Host, worker.Conn.RemoteAddr(), worker.Id, worker.config })
+    if w.err != nil {
         worker.LogError("Failed to serve: %s\n", requestPath)
-        return gophorErr
+        return w.err
     }
     worker.Log("Served: %s\n", requestPath)
+    return nil
+}
+
+/* responseWriter:
+ * ResponseWriter passed to handlers, writing straight to the
+ * worker's connection. The first error, from either the handler
+ * or the socket, is kept so the worker can send its response.
+ */
+type responseWriter struct {
+    worker *Worker
+    err    *GophorError
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+    gophorErr := w.worker.SendRaw(b)
+    if gophorErr != nil {
+        w.WriteError(gophorErr)
+        return 0, gophorErr
+    }
+    return len(b), nil
+}
 
-    /* Serve response */
-    return worker.SendRaw(response)
+func (w *responseWriter) WriteError(gophorErr *GophorError) {
+    if w.err == nil {
+        w.err = gophorErr
+    }
 }
 
 func readUpToFirstTabOrCrlf(data []byte) string {
@@ -141,6 +208,23 @@ func readUpToFirstTabOrCrlf(data []byte) string {
     return dataStr
 }
 
+/* Read search query (type 7 items) following the first tab, up to next tab or cr-lf */
+func readQuery(data []byte) string {
+    i := bytes.IndexByte(data, '\t')
+    if i < 0 {
+        return ""
+    } else if j := bytes.Index(data, []byte(DOSLineEnd)); j >= 0 && j < i {
+        /* Tab came after end of request line */
+        return ""
+    }
+
+    query := data[i+1:]
+    if j := bytes.IndexAny(query, "\t\r\n"); j >= 0 {
+        query = query[:j]
+    }
+    return string(query)
+}
+
 func sanitizePath(dataStr string) string {
     /* Clean path and trim '/' prefix if still exists */
     requestPath := strings.TrimPrefix(path.Clean(dataStr), "/")
@@ -7,7 +7,9 @@ import (
     "syscall"
     "os/signal"
     "flag"
-    "time"
+    "log"
+    "net"
+    "github.com/EaterLabs/gophor/gopher"
 )
 
 /*
@@ -28,79 +30,53 @@ import (
 */
 import "C"
 
-var (
-    Config *ServerConfig
-)
-
 func main() {
-    /* Setup the entire server, getting slice of listeners in return */
-    listeners := setupServer()
-
-    /* Handle signals so we can _actually_ shutdowm */
-    signals := make(chan os.Signal)
-    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-
-    /* Start accepting connections on any supplied listeners */
-    for _, l := range listeners {
-        go func() {
-            Config.LogSystem("Listening on: gopher://%s\n", l.Addr())
-
-            for {
-                newConn, err := l.Accept()
-                if err != nil {
-                    Config.LogSystemError("Error accepting connection: %s\n", err.Error())
-                    continue
-                }
-
-                /* Run this in it's own goroutine so we can go straight back to accepting */
-                go func() {
-                    NewWorker(newConn).Serve()
-                }()
-            }
-        }()
+    /* Subcommands are handled before server flags are parsed */
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+            case "fetch":
+                os.Exit(runFetch(os.Args[2:]))
+            case "check":
+                os.Exit(runCheck(os.Args[2:]))
+            case "export":
+                os.Exit(runExport(os.Args[2:]))
+        }
     }
 
-    /* When OS signal received, we close-up */
-    sig := <-signals
-    Config.LogSystem("Signal received: %v. Shutting down...\n", sig)
-    os.Exit(0)
-}
-
-func setupServer() []*GophorListener {
-    /* First we setup all the flags and parse them... */
+    /* Setup the entire server, getting listener in return */
+    server, listener := setupServer()
 
-    /* Base server settings */
-    serverRoot        := flag.String("root", "/var/gopher", "Change server root directory.")
-    serverHostname    := flag.String("hostname", "127.0.0.1", "Change server hostname (FQDN).")
-    serverPort        := flag.Int("port", 70, "Change server port (0 to disable unencrypted traffic).")
-    serverBindAddr    := flag.String("bind-addr", "127.0.0.1", "Change server socket bind address")
-    execAs            := flag.String("user", "", "Drop to supplied user's UID and GID permissions before execution.")
+    /* Handle signals so we can _actually_ shutdowm, or reload */
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-    /* User supplied caps.txt information */
-    serverDescription := flag.String("description", "Gophor: a Gopher server in GoLang", "Change server description in generated caps.txt.")
-    serverAdmin       := flag.String("admin-email", "", "Change admin email in generated caps.txt.")
-    serverGeoloc      := flag.String("geoloc", "", "Change server gelocation string in generated caps.txt.")
+    /* Start accepting connections */
+    go server.Serve(listener)
 
-    /* Content settings */
-    footerText        := flag.String("footer", "", "Change gophermap footer text (Unix new-line separated lines).")
-    footerSeparator   := flag.Bool("no-footer-separator", false, "Disable footer line separator.")
+    /* When OS signal received, we reload or close-up */
+    for {
+        sig := <-signals
+        if sig == syscall.SIGHUP {
+            server.Config.LogSystem("Signal received: %v. Reloading...\n", sig)
+            server.Reload()
+            continue
+        }
 
-    pageWidth         := flag.Int("page-width", 80, "Change page width used when formatting output.")
-    restrictedFiles   := flag.String("restrict-files", "", "New-line separated list of regex statements restricting files from showing in directory listings.")
+        server.Config.LogSystem("Signal received: %v. Shutting down...\n", sig)
+        os.Exit(0)
+    }
+}
 
-    /* Logging settings */
-    systemLogPath     := flag.String("system-log", "", "Change server system log file (blank outputs to stderr).")
-    accessLogPath     := flag.String("access-log", "", "Change server access log file (blank outputs to stderr).")
-    logType           := flag.Int("log-type", 0, "Change server log file handling -- 0:default 1:disable")
+func setupServer() (*gopher.Server, net.Listener) {
+    /* First we setup all the flags and parse them, straight into server options... */
+    options := gopher.DefaultServerOptions()
 
-    /* Cache settings */
-    cacheCheckFreq    := flag.String("cache-check", "60s", "Change file cache freshness check frequency.")
-    cacheSize         := flag.Int("cache-size", 50, "Change file cache size, measured in file count.")
-    cacheFileSizeMax  := flag.Float64("cache-file-max", 0.5, "Change maximum file size to be cached (in megabytes).")
-    cacheDisabled     := flag.Bool("disable-cache", false, "Disable file caching.")
+    /* Server settings, plus those only for running the server */
+    addServerFlags(flag.CommandLine, options)
+    execAs := flag.String("user", "", "Drop to supplied user's UID and GID permissions before execution.")
 
     /* Version string */
-    version           := flag.Bool("version", false, "Print version information.")
+    version := flag.Bool("version", false, "Print version information.")
 
     /* Parse parse parse!! */
     flag.Parse()
@@ -108,16 +84,14 @@ func setupServer() []*GophorListener {
         printVersionExit()
     }
 
-    /* Setup the server configuration instance and enter as much as we can right now */
-    Config = new(ServerConfig)
-    Config.RootDir     = *serverRoot
-    Config.PageWidth   = *pageWidth
-
-    /* Have to be set AFTER page width variable set */
-    Config.FooterText  = formatGophermapFooter(*footerText, !*footerSeparator)
+    /* Content is served from the root of the chroot entered below */
+    options.Source = os.DirFS("/")
 
-    /* Setup Gophor logging system */
-    Config.SystemLogger, Config.AccessLogger = setupLogging(*logType, *systemLogPath, *accessLogPath)
+    /* Build the server. Done before chroot so any supplied file paths (and passwd) are as supplied */
+    server, gophorErr := gopher.NewServer(options)
+    if gophorErr != nil {
+        log.Fatalf("Error setting up server: %s\n", gophorErr.Error())
+    }
 
     /* Get UID + GID for requested user. Has to be done BEFORE chroot or it fails */
     var uid, gid int
@@ -127,12 +101,12 @@ func setupServer() []*GophorListener {
         gid = 1000
     } else if *execAs == "root" {
         /* Naughty, naughty! */
-        Config.LogSystemFatal("Gophor does not support directly running as root\n")
+        server.Config.LogSystemFatal("Gophor does not support directly running as root\n")
     } else {
         /* Try lookup specified username */
         user, err := user.Lookup(*execAs)
         if err != nil {
-            Config.LogSystemFatal("Error getting information for requested user %s: %s\n", *execAs, err)
+            server.Config.LogSystemFatal("Error getting information for requested user %s: %s\n", *execAs, err)
         }
 
         /* These values should be coming straight out of /etc/passwd, so assume safe */
@@ -141,101 +115,109 @@ func setupServer() []*GophorListener {
     }
 
     /* Enter server dir */
-    enterServerDir(*serverRoot)
-    Config.LogSystem("Entered server directory: %s\n", *serverRoot)
+    enterServerDir(server, options.RootDir)
+    server.Config.LogSystem("Entered server directory: %s\n", options.RootDir)
 
     /* Try enter chroot if requested */
-    chrootServerDir(*serverRoot)
-    Config.LogSystem("Chroot success, new root: %s\n", *serverRoot)
-
-    /* Setup listeners */
-    listeners := make([]*GophorListener, 0)
+    chrootServerDir(server, options.RootDir)
+    server.Config.LogSystem("Chroot success, new root: %s\n", options.RootDir)
 
     /* If requested, setup unencrypted listener */
-    if *serverPort != 0 {
-        l, err := BeginGophorListen(*serverBindAddr, *serverHostname, strconv.Itoa(*serverPort))
-        if err != nil {
-            Config.LogSystemFatal("Error setting up (unencrypted) listener: %s\n", err.Error())
-        }
-        listeners = append(listeners, l)
-    } else {
-        Config.LogSystemFatal("No valid port to listen on :(\n")
+    if options.Port == 0 {
+        server.Config.LogSystemFatal("No valid port to listen on :(\n")
+    }
+    listener, err := net.Listen("tcp", options.BindAddr+":"+strconv.Itoa(options.Port))
+    if err != nil {
+        server.Config.LogSystemFatal("Error setting up (unencrypted) listener: %s\n", err.Error())
     }
 
     /* Drop privileges to retrieved UID + GID */
-    setPrivileges(uid, gid)
-    Config.LogSystem("Successfully dropped privileges to UID:%d GID:%d\n", uid, gid)
-
-    /* Compile user restricted files regex if supplied */
-    if *restrictedFiles != "" {
-        Config.RestrictedFiles = compileUserRestrictedFilesRegex(*restrictedFiles)
-
-        /* Setup the listDir function to use regex matching */
-        listDir = _listDirRegexMatch
-    } else {
-        /* Setup the listDir function to skip regex matching */
-        listDir = _listDir
-    }
+    setPrivileges(server, uid, gid)
+    server.Config.LogSystem("Successfully dropped privileges to UID:%d GID:%d\n", uid, gid)
 
-    /* Setup file cache */
-    Config.FileSystem = new(FileSystem)
+    return server, listener
+}
 
-    if !*cacheDisabled {
-        /* Parse suppled cache check frequency time */
-        fileMonitorSleepTime, err := time.ParseDuration(*cacheCheckFreq)
-        if err != nil {
-            Config.LogSystemFatal("Error parsing supplied cache check frequency %s: %s\n", *cacheCheckFreq, err)
-        }
+/* Add flags for all server options to flags, defaulting to the current options */
+func addServerFlags(flags *flag.FlagSet, options *gopher.ServerOptions) {
+    /* Base server settings */
+    flags.StringVar(&options.RootDir, "root", options.RootDir, "Change server root directory.")
+    flags.StringVar(&options.Hostname, "hostname", options.Hostname, "Change server hostname (FQDN).")
+    flags.IntVar(&options.Port, "port", options.Port, "Change server port (0 to disable unencrypted traffic).")
+    flags.StringVar(&options.BindAddr, "bind-addr", options.BindAddr, "Change server socket bind address")
 
-        /* Init file cache */
-        Config.FileSystem.Init(*cacheSize, *cacheFileSizeMax)
-        Config.LogSystem("File caching enabled with: maxcount=%d maxsize=%.3fMB\n", *cacheSize, *cacheFileSizeMax)
+    /* User supplied caps.txt information */
+    flags.StringVar(&options.Description, "description", options.Description, "Change server description in generated caps.txt.")
+    flags.StringVar(&options.AdminEmail, "admin-email", options.AdminEmail, "Change admin email in generated caps.txt.")
+    flags.StringVar(&options.Geoloc, "geoloc", options.Geoloc, "Change server gelocation string in generated caps.txt.")
 
-        /* Before file monitor or any kind of new goroutines started,
-         * check if we need to cache generated policy files
-         */
-        cachePolicyFiles(*serverDescription, *serverAdmin, *serverGeoloc)
+    /* Content settings */
+    flags.StringVar(&options.FooterText, "footer", options.FooterText, "Change gophermap footer text (Unix new-line separated lines).")
+    flags.BoolVar(&options.NoFooterSeparator, "no-footer-separator", options.NoFooterSeparator, "Disable footer line separator.")
+
+    flags.IntVar(&options.PageWidth, "page-width", options.PageWidth, "Change page width used when formatting output.")
+    flags.StringVar(&options.RestrictedFiles, "restrict-files", options.RestrictedFiles, "New-line separated list of regex statements restricting files from showing in directory listings.")
+    flags.StringVar(&options.ListSort, "list-sort", options.ListSort, "Change directory listing sort order -- name, name-reverse, mtime, mtime-reverse (newest), size, size-reverse")
+    flags.BoolVar(&options.ListDirsFirst, "list-dirs-first", options.ListDirsFirst, "List directories before files in directory listings.")
+    flags.StringVar(&options.ListStyle, "list-style", options.ListStyle, "Change directory listing style -- plain, suffix, columns (size and date shown with the latter two)")
+    flags.BoolVar(&options.RenderMarkdown, "render-markdown", options.RenderMarkdown, "Render Markdown files as gophermaps (original available with '"+gopher.MarkdownRawSuffix+"' appended to selector).")
+    flags.StringVar(&options.UserDir, "user-dir", options.UserDir, "Serve this directory within each user's home under '/~user' selectors (blank disables).")
+    flags.StringVar(&options.TypeMapPath, "type-map", options.TypeMapPath, "Item type map file adding to / overriding extension types (re-read on SIGHUP).")
+    flags.StringVar(&options.MountTablePath, "mounts", options.MountTablePath, "Mount table file mapping selector prefixes to (union) directories outside the root.")
+    flags.StringVar(&options.RewriteRulesPath, "rewrite-rules", options.RewriteRulesPath, "Selector rewrite / redirect / gone rules file (re-read on SIGHUP).")
+    flags.Float64Var(&options.ArchiveMaxSize, "archive-max-size", options.ArchiveMaxSize, "Change maximum size of a file served from within a browsed archive (in megabytes).")
+    flags.StringVar(&options.DirArchive, "dir-archive", options.DirArchive, "Serve archive of each directory's visible contents at the directory selector plus extension -- tar, tar.gz, zip (blank disables).")
+    flags.Float64Var(&options.DirArchiveMaxSize, "dir-archive-max-size", options.DirArchiveMaxSize, "Change maximum total size of files in a directory archive (in megabytes).")
+    flags.IntVar(&options.DirArchiveMaxFiles, "dir-archive-max-files", options.DirArchiveMaxFiles, "Change maximum number of entries (files and directories) in a directory archive.")
+    flags.StringVar(&options.SearchSelector, "search", options.SearchSelector, "Serve full-text search of text files and gophermaps at this (type 7) selector (blank disables).")
+    flags.DurationVar(&options.SearchRefresh, "search-refresh", options.SearchRefresh, "Change frequency the search index is checked for changed files.")
 
-        /* Start file cache freshness checker */
-        go startFileMonitor(fileMonitorSleepTime)
-        Config.LogSystem("File cache freshness monitor started with frequency: %s\n", fileMonitorSleepTime)
-    } else {
-        /* File caching disabled, init with zero max size so nothing gets cached */
-        Config.FileSystem.Init(2, 0)
-        Config.LogSystem("File caching disabled\n")
+    /* Logging settings */
+    flags.StringVar(&options.SystemLogPath, "system-log", options.SystemLogPath, "Change server system log file (blank outputs to stderr).")
+    flags.StringVar(&options.AccessLogPath, "access-log", options.AccessLogPath, "Change server access log file (blank outputs to stderr).")
+    flags.IntVar(&options.LogType, "log-type", options.LogType, "Change server log file handling -- 0:default 1:disable")
+    flags.StringVar(&options.SystemLogLevel, "system-log-level", options.SystemLogLevel, "Change system log level -- debug, info, warn, error")
+    flags.StringVar(&options.AccessLogLevel, "access-log-level", options.AccessLogLevel, "Change access log level -- debug, info, warn, error")
+    flags.BoolVar(&options.Debug, "debug", options.Debug, "Enable debug mode, tracing each request through the system (implies debug system log level).")
 
-        /* Safe to cache policy files now */
-        cachePolicyFiles(*serverDescription, *serverAdmin, *serverGeoloc)
-    }
+    /* Cache settings */
+    flags.DurationVar(&options.CacheCheckFreq, "cache-check", options.CacheCheckFreq, "Change file cache freshness check frequency.")
+    flags.IntVar(&options.CacheSize, "cache-size", options.CacheSize, "Change file cache size, measured in file count.")
+    flags.Float64Var(&options.CacheFileMax, "cache-file-max", options.CacheFileMax, "Change maximum file size to be cached (in megabytes).")
+    flags.BoolVar(&options.CacheDisabled, "disable-cache", options.CacheDisabled, "Disable file caching.")
+}
 
-    /* Return the created listeners slice :) */
-    return listeners
+func printVersionExit() {
+    /* Reset the flags before printing version */
+    log.SetFlags(0)
+    log.Printf("%s\n", gopher.GophorVersion)
+    os.Exit(0)
 }
 
-func enterServerDir(path string) {
+func enterServerDir(server *gopher.Server, path string) {
     err := syscall.Chdir(path)
     if err != nil {
-        Config.LogSystemFatal("Error changing dir to server root %s: %s\n", path, err.Error())
+        server.Config.LogSystemFatal("Error changing dir to server root %s: %s\n", path, err.Error())
     }
 }
 
-func chrootServerDir(path string) {
+func chrootServerDir(server *gopher.Server, path string) {
     err := syscall.Chroot(path)
     if err != nil {
-        Config.LogSystemFatal("Error chroot'ing into server root %s: %s\n", path, err.Error())
+        server.Config.LogSystemFatal("Error chroot'ing into server root %s: %s\n", path, err.Error())
     }
 
     /* Change to server root just to ensure we're sitting at root of chroot */
     err = syscall.Chdir("/")
     if err != nil {
-        Config.LogSystemFatal("Error changing to root of chroot dir: %s\n", err.Error())
+        server.Config.LogSystemFatal("Error changing to root of chroot dir: %s\n", err.Error())
     }
 }
 
-func setPrivileges(execUid, execGid int) {
+func setPrivileges(server *gopher.Server, execUid, execGid int) {
     /* Check root privileges aren't being requested */
     if execUid == 0 || execGid == 0 {
-        Config.LogSystemFatal("Gophor does not support directly running as root\n")
+        server.Config.LogSystemFatal("Gophor does not support directly running as root\n")
     }
 
     /* Get currently running user info */
@@ -246,7 +228,7 @@ func setPrivileges(execUid, execGid int) {
         /* C-bind setgid */
         result := C.setgid(C.uint(execGid))
         if result != 0 {
-            Config.LogSystemFatal("Failed setting GID %d: %d\n", execGid, result)
+            server.Config.LogSystemFatal("Failed setting GID %d: %d\n", execGid, result)
         }
     }
 
@@ -255,7 +237,7 @@ func setPrivileges(execUid, execGid int) {
         /* C-bind setuid */
         result := C.setuid(C.uint(execUid))
         if result != 0 {
-            Config.LogSystemFatal("Failed setting UID %d: %d\n", execUid, result)
+            server.Config.LogSystemFatal("Failed setting UID %d: %d\n", execUid, result)
         }
     }
 }